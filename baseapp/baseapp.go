@@ -1,8 +1,13 @@
 package baseapp
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math/big"
 	"runtime/debug"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
@@ -18,6 +23,32 @@ import (
 
 var mainHeaderKey = []byte("header")
 
+// The block gas limit is kept in the main store, as part of the app
+// state, so that all the validators agree on it.
+var mainBlockGasLimitKey = []byte("blockGasLimit")
+
+// The chain ID of the last block is kept in the main store, so that it
+// is committed along with the block, and the Check state has it again
+// after a restart, a rollback or a snapshot import.
+var mainChainIDKey = []byte("chainID")
+
+// DefaultTxGasLimit is the gas limit applied to each transaction unless
+// overridden with SetTxGasLimit or by the AnteHandler.
+const DefaultTxGasLimit sdk.Gas = 100000
+
+// runTxMode selects the state a tx is run against in runTx.
+type runTxMode uint8
+
+const (
+	// Run the ante handler against the check state.
+	runTxModeCheck runTxMode = iota
+	// Run the ante handler and the msgs against a throwaway cache
+	// of the check state, without verifying signatures.
+	runTxModeSimulate
+	// Run the ante handler and the msgs against the deliver state.
+	runTxModeDeliver
+)
+
 // The ABCI application
 type BaseApp struct {
 	// initialized on creation
@@ -27,20 +58,36 @@ type BaseApp struct {
 	cms    sdk.CommitMultiStore // Main (uncached) state
 	router Router               // handle any kind of message
 
+	// set on loading
+	mainKey sdk.StoreKey // main store, of the header, chain ID and block gas limit
+
+	// may be empty
+	queryRouter QueryRouter // handle "/custom/<route>/..." queries
+
 	// must be set
 	txDecoder   sdk.TxDecoder   // unmarshal []byte into sdk.Tx
 	anteHandler sdk.AnteHandler // ante handler for fee and auth
 
+	// defaults to DefaultTxGasLimit
+	txGasLimit sdk.Gas // gas limit of the GasMeter given to each tx
+
+	// may be empty, node-local (not part of consensus)
+	minGasPrices sdk.Coins // min fee per unit of gas accepted by CheckTx
+
 	// may be nil
-	initChainer  sdk.InitChainer  // initialize state with validators and state blob
-	beginBlocker sdk.BeginBlocker // logic to run before any txs
-	endBlocker   sdk.EndBlocker   // logic to run after all txs, and to determine valset changes
+	initChainer      sdk.InitChainer      // initialize state with validators and state blob
+	beginBlocker     sdk.BeginBlocker     // logic to run before any txs
+	endBlocker       sdk.EndBlocker       // logic to run after all txs, and to determine valset changes
+	appStateExporter sdk.AppStateExporter // export the state in the format read by initChainer
 
 	//--------------------
 	// Volatile
 	// .msCheck and .ctxCheck are set on initialization and reset on Commit.
 	// .msDeliver and .ctxDeliver are (re-)set on BeginBlock.
 	// .valUpdates accumulate in DeliverTx and reset in BeginBlock.
+	// .blockGasLimit is read from the main store in BeginBlock.
+	// .blockGasConsumed accumulates in DeliverTx and resets in BeginBlock.
+	// .txIndex counts the DeliverTxs of the block, for tracing.
 	// QUESTION: should we put valUpdates in the ctxDeliver?
 
 	msCheck    sdk.CacheMultiStore // CheckTx state, a cache-wrap of `.cms`
@@ -48,6 +95,10 @@ type BaseApp struct {
 	ctxCheck   sdk.Context         // CheckTx context
 	ctxDeliver sdk.Context         // DeliverTx context
 	valUpdates []abci.Validator    // cached validator changes from DeliverTx
+
+	blockGasLimit    sdk.Gas // max total gas of the txs in this block, zero means no limit
+	blockGasConsumed sdk.Gas // gas consumed by DeliverTx in this block
+	txIndex          int     // index of the next DeliverTx in this block
 }
 
 var _ abci.Application = &BaseApp{}
@@ -55,11 +106,14 @@ var _ abci.Application = &BaseApp{}
 // Create and name new BaseApp
 func NewBaseApp(name string, logger log.Logger, db dbm.DB) *BaseApp {
 	return &BaseApp{
-		logger: logger,
-		name:   name,
-		db:     db,
-		cms:    store.NewCommitMultiStore(db),
-		router: NewRouter(),
+		logger:      logger,
+		name:        name,
+		db:          db,
+		cms:         store.NewCommitMultiStore(db),
+		router:      NewRouter(),
+		queryRouter: NewQueryRouter(),
+
+		txGasLimit: DefaultTxGasLimit,
 	}
 }
 
@@ -75,11 +129,24 @@ func (app *BaseApp) MountStoresIAVL(keys ...*sdk.KVStoreKey) {
 	}
 }
 
+// Mount transient stores to the provided keys in the BaseApp multistore
+func (app *BaseApp) MountStoresTransient(keys ...*sdk.TransientStoreKey) {
+	for _, key := range keys {
+		app.MountStore(key, sdk.StoreTypeTransient)
+	}
+}
+
 // Mount a store to the provided key in the BaseApp multistore
 func (app *BaseApp) MountStore(key sdk.StoreKey, typ sdk.StoreType) {
 	app.cms.MountStoreWithDB(key, typ, app.db)
 }
 
+// Mount a store to the provided key in the BaseApp multistore, on its own db.
+// IAVL stores can't share a db, except for the BaseApp db.
+func (app *BaseApp) MountStoreWithDB(key sdk.StoreKey, typ sdk.StoreType, db dbm.DB) {
+	app.cms.MountStoreWithDB(key, typ, db)
+}
+
 // nolint - Set functions
 func (app *BaseApp) SetTxDecoder(txDecoder sdk.TxDecoder) {
 	app.txDecoder = txDecoder
@@ -87,6 +154,9 @@ func (app *BaseApp) SetTxDecoder(txDecoder sdk.TxDecoder) {
 func (app *BaseApp) SetInitChainer(initChainer sdk.InitChainer) {
 	app.initChainer = initChainer
 }
+func (app *BaseApp) SetAppStateExporter(exporter sdk.AppStateExporter) {
+	app.appStateExporter = exporter
+}
 func (app *BaseApp) SetBeginBlocker(beginBlocker sdk.BeginBlocker) {
 	app.beginBlocker = beginBlocker
 }
@@ -97,9 +167,49 @@ func (app *BaseApp) SetAnteHandler(ah sdk.AnteHandler) {
 	// deducts fee from payer, verifies signatures and nonces, sets Signers to ctx.
 	app.anteHandler = ah
 }
+func (app *BaseApp) SetTxGasLimit(limit sdk.Gas) {
+	// NOTE: the gas limit of DeliverTx and CheckTx until the AnteHandler
+	// sets the tx's own, e.g. from its fee.
+	app.txGasLimit = limit
+}
+func (app *BaseApp) SetMinGasPrices(prices sdk.Coins) {
+	// NOTE: only applies to CheckTx, so each node may choose its own.
+	app.minGasPrices = prices
+}
+func (app *BaseApp) SetPruning(pruning sdk.PruningOptions) {
+	// NOTE: must be called before loading the stores.
+	app.cms.SetPruning(pruning)
+}
+func (app *BaseApp) SetStoreUpgrades(height int64, upgrades sdk.StoreUpgrades) {
+	// NOTE: must be called before loading the stores, by the first
+	// binary to run the block at height, i.e. after committing height-1.
+	app.cms.SetStoreUpgrades(height, upgrades)
+}
+func (app *BaseApp) SetCommitMultiStoreTracer(w io.Writer) {
+	// NOTE: traces the store operations of CheckTx, DeliverTx, etc.
+	// as JSON lines, with the block height and tx index of DeliverTxs.
+	app.cms.SetTracer(w, app.logger)
+}
 
 // nolint - Get functions
-func (app *BaseApp) Router() Router { return app.router }
+func (app *BaseApp) Router() Router           { return app.router }
+func (app *BaseApp) QueryRouter() QueryRouter { return app.queryRouter }
+
+// SetBlockGasLimit sets the max total gas of the txs in a block, from the
+// next block on, e.g. from the genesis state in the InitChainer.  It is
+// part of the app state, so all the validators apply the same limit.
+// Zero means no limit.
+func (app *BaseApp) SetBlockGasLimit(ctx sdk.Context, limit sdk.Gas) {
+	bz := make([]byte, 8)
+	binary.BigEndian.PutUint64(bz, uint64(limit))
+	ctx.KVStore(app.mainKey).Set(mainBlockGasLimitKey, bz)
+}
+
+// BlockGasLimit returns the block gas limit in the app state of ctx,
+// e.g. to export it.
+func (app *BaseApp) BlockGasLimit(ctx sdk.Context) sdk.Gas {
+	return loadBlockGasLimit(ctx.KVStore(app.mainKey))
+}
 
 // load latest application version
 func (app *BaseApp) LoadLatestVersion(mainKey sdk.StoreKey) error {
@@ -113,6 +223,52 @@ func (app *BaseApp) LoadVersion(version int64, mainKey sdk.StoreKey) error {
 	return app.initFromStore(mainKey)
 }
 
+// ExportSnapshot writes a snapshot of the latest committed state into dir,
+// from which another node can be bootstrapped with ImportSnapshot.
+func (app *BaseApp) ExportSnapshot(dir string) (sdk.CommitID, error) {
+	return app.cms.ExportSnapshot(dir, store.DefaultSnapshotChunkSize)
+}
+
+// ImportSnapshot loads the state from a snapshot in dir, instead of
+// LoadLatestVersion.  The app's db must be empty.  The returned CommitID
+// should be checked against the AppHash of the chain at its height.
+func (app *BaseApp) ImportSnapshot(dir string, mainKey sdk.StoreKey) (sdk.CommitID, error) {
+	commitID, err := app.cms.ImportSnapshot(dir)
+	if err != nil {
+		return sdk.CommitID{}, err
+	}
+	app.mainKey = mainKey
+	app.setCheckState(abci.Header{ChainID: app.loadChainID()})
+	return commitID, nil
+}
+
+// DiffVersions calls fn with the keys of the IAVL stores that differ between
+// two committed heights, e.g. to find where the state of two nodes diverged,
+// until fn returns true.
+func (app *BaseApp) DiffVersions(from, to int64, fn func(storeName string, diff sdk.KVDiff) (stop bool)) error {
+	return app.cms.DiffVersions(from, to, fn)
+}
+
+// ExportAppStateJSON returns the app state at a committed height, or at
+// the latest height if height is zero, in the format read by the
+// InitChainer, e.g. to restart the chain from it for a hard fork.
+func (app *BaseApp) ExportAppStateJSON(height int64) (json.RawMessage, error) {
+	if app.appStateExporter == nil {
+		return nil, fmt.Errorf("No AppStateExporter is set")
+	}
+	if height == 0 {
+		height = app.LastBlockHeight()
+	}
+
+	// Never written, as for queries.
+	ms, err := app.cms.CacheMultiStoreWithVersion(height)
+	if err != nil {
+		return nil, fmt.Errorf("Can't export height %v: %v", height, err)
+	}
+	ctx := sdk.NewContext(ms, abci.Header{Height: height}, true, nil)
+	return app.appStateExporter(ctx)
+}
+
 // the last CommitID of the multistore
 func (app *BaseApp) LastCommitID() sdk.CommitID {
 	return app.cms.LastCommitID()
@@ -133,6 +289,7 @@ func (app *BaseApp) initFromStore(mainKey sdk.StoreKey) error {
 	if main == nil {
 		return errors.New("BaseApp expects MultiStore with 'main' KVStore")
 	}
+	app.mainKey = mainKey
 
 	// if we've committed before, we expect main://<mainHeaderKey>
 	if !lastCommitID.IsZero() {
@@ -153,12 +310,35 @@ func (app *BaseApp) initFromStore(mainKey sdk.StoreKey) error {
 	}
 
 	// initialize Check state
-	app.msCheck = app.cms.CacheMultiStore()
-	app.ctxCheck = app.NewContext(true, abci.Header{})
+	app.setCheckState(abci.Header{ChainID: app.loadChainID()})
 
 	return nil
 }
 
+// Rollback deletes the committed versions after version and resets the
+// Check state to it, e.g. to replay the blocks after an app hash mismatch.
+// It is called instead of LoadLatestVersion.
+func (app *BaseApp) Rollback(version int64, mainKey sdk.StoreKey) error {
+	err := app.cms.RollbackToVersion(version)
+	if err != nil {
+		return err
+	}
+	app.mainKey = mainKey
+	app.setCheckState(abci.Header{ChainID: app.loadChainID()})
+	return nil
+}
+
+// Returns the chain ID of the last committed block, or "" before the first.
+func (app *BaseApp) loadChainID() string {
+	return string(app.cms.GetKVStore(app.mainKey).Get(mainChainIDKey))
+}
+
+// (Re-)sets the Check state on top of the committed state.
+func (app *BaseApp) setCheckState(header abci.Header) {
+	app.msCheck = app.cms.CacheMultiStore()
+	app.ctxCheck = app.NewContext(true, header).WithTraceContext(sdk.TraceContext{"checkTx": true})
+}
+
 // NewContext returns a new Context with the correct store, the given header, and nil txBytes.
 func (app *BaseApp) NewContext(isCheckTx bool, header abci.Header) sdk.Context {
 	if isCheckTx {
@@ -210,22 +390,15 @@ func (app *BaseApp) InitChain(req abci.RequestInitChain) (res abci.ResponseInitC
 	return
 }
 
-// Implements ABCI.
-// Delegates to CommitMultiStore if it implements Queryable
-func (app *BaseApp) Query(req abci.RequestQuery) (res abci.ResponseQuery) {
-	queryable, ok := app.cms.(sdk.Queryable)
-	if !ok {
-		msg := "application doesn't support queries"
-		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
-	}
-	return queryable.Query(req)
-}
-
 // Implements ABCI
 func (app *BaseApp) BeginBlock(req abci.RequestBeginBlock) (res abci.ResponseBeginBlock) {
 	app.msDeliver = app.cms.CacheMultiStore()
-	app.ctxDeliver = app.NewContext(false, req.Header)
+	app.ctxDeliver = app.NewContext(false, req.Header).
+		WithTraceContext(sdk.TraceContext{"blockHeight": req.Header.Height})
 	app.valUpdates = nil
+	app.blockGasLimit = loadBlockGasLimit(app.msDeliver.GetKVStore(app.mainKey))
+	app.blockGasConsumed = 0
+	app.txIndex = 0
 	if app.beginBlocker != nil {
 		res = app.beginBlocker(app.ctxDeliver, req)
 	}
@@ -241,7 +414,7 @@ func (app *BaseApp) CheckTx(txBytes []byte) (res abci.ResponseCheckTx) {
 	if err != nil {
 		result = err.Result()
 	} else {
-		result = app.runTx(true, txBytes, tx)
+		result = app.runTx(runTxModeCheck, txBytes, tx)
 	}
 
 	return abci.ResponseCheckTx{
@@ -266,7 +439,7 @@ func (app *BaseApp) DeliverTx(txBytes []byte) (res abci.ResponseDeliverTx) {
 	if err != nil {
 		result = err.Result()
 	} else {
-		result = app.runTx(false, txBytes, tx)
+		result = app.runTx(runTxModeDeliver, txBytes, tx)
 	}
 
 	// After-handler hooks.
@@ -291,65 +464,148 @@ func (app *BaseApp) DeliverTx(txBytes []byte) (res abci.ResponseDeliverTx) {
 
 // Mostly for testing
 func (app *BaseApp) Check(tx sdk.Tx) (result sdk.Result) {
-	return app.runTx(true, nil, tx)
+	return app.runTx(runTxModeCheck, nil, tx)
 }
 func (app *BaseApp) Deliver(tx sdk.Tx) (result sdk.Result) {
-	return app.runTx(false, nil, tx)
+	return app.runTx(runTxModeDeliver, nil, tx)
+}
+
+// Simulate runs the tx against the check state without persisting anything,
+// e.g. to estimate the gas it will use. Signatures are not verified.
+func (app *BaseApp) Simulate(tx sdk.Tx) (result sdk.Result) {
+	return app.runTx(runTxModeSimulate, nil, tx)
 }
 
 // txBytes may be nil in some cases, eg. in tests.
 // Also, in the future we may support "internal" transactions.
-func (app *BaseApp) runTx(isCheckTx bool, txBytes []byte, tx sdk.Tx) (result sdk.Result) {
+func (app *BaseApp) runTx(mode runTxMode, txBytes []byte, tx sdk.Tx) (result sdk.Result) {
+	isDeliverTx := mode == runTxModeDeliver
+
+	// Get the context, and the store it writes to
+	var ctx sdk.Context
+	var ms sdk.CacheMultiStore
+	switch mode {
+	case runTxModeCheck:
+		ms = app.msCheck
+		ctx = app.ctxCheck.WithTxBytes(txBytes)
+	case runTxModeSimulate:
+		// NOTE: nothing is ever written back to app.msCheck.
+		ms = app.msCheck.CacheMultiStore()
+		ctx = app.ctxCheck.WithTxBytes(txBytes).WithMultiStore(ms)
+	default:
+		// Attribute the traced store operations of this tx to it,
+		// including those of the ante handler.
+		ms = app.msDeliver
+		ctx = app.ctxDeliver.WithTxBytes(txBytes).
+			WithTraceContext(sdk.TraceContext{"txIndex": app.txIndex})
+	}
+
+	// Every tx runs against its own GasMeter.
+	// The AnteHandler may replace it, e.g. with one limited by the tx fee.
+	// Simulated txs aren't limited, so that all the gas they use is reported.
+	if mode == runTxModeSimulate {
+		ctx = ctx.WithGasMeter(sdk.NewInfiniteGasMeter())
+	} else {
+		ctx = ctx.WithGasMeter(sdk.NewGasMeter(app.txGasLimit))
+	}
 
 	// Handle any panics.
 	defer func() {
 		if r := recover(); r != nil {
-			log := fmt.Sprintf("Recovered: %v\nstack:\n%v", r, string(debug.Stack()))
-			result = sdk.ErrInternal(log).Result()
+			switch rType := r.(type) {
+			case sdk.ErrorOutOfGas:
+				log := fmt.Sprintf("Out of gas in location: %v", rType.Descriptor)
+				result = sdk.ErrOutOfGas(log).Result()
+			default:
+				log := fmt.Sprintf("Recovered: %v\nstack:\n%v", r, string(debug.Stack()))
+				result = sdk.ErrInternal(log).Result()
+			}
+		}
+		result.GasWanted = ctx.GasMeter().Limit()
+		result.GasUsed = ctx.GasMeter().GasConsumed()
+
+		// Charge the block for the gas this tx consumed.
+		if isDeliverTx {
+			app.consumeBlockGas(result)
+			app.txIndex++
 		}
 	}()
 
-	// Get the Msg.
-	var msg = tx.GetMsg()
-	if msg == nil {
-		return sdk.ErrInternal("Tx.GetMsg() returned nil").Result()
+	// Reject the tx outright if the block is already full.
+	if isDeliverTx && app.blockGasLimit > 0 && app.blockGasConsumed >= app.blockGasLimit {
+		return sdk.ErrOutOfGas("Block gas limit reached").Result()
 	}
 
-	// Validate the Msg.
-	err := msg.ValidateBasic()
-	if err != nil {
-		return err.Result()
+	// Get the Msgs.
+	var msgs = tx.GetMsgs()
+	if len(msgs) == 0 {
+		return sdk.ErrTxParse("Tx.GetMsgs() returned no msgs").Result()
 	}
 
-	// Get the context
-	var ctx sdk.Context
-	if isCheckTx {
-		ctx = app.ctxCheck.WithTxBytes(txBytes)
-	} else {
-		ctx = app.ctxDeliver.WithTxBytes(txBytes)
+	// Validate the Msgs, and make sure they can be routed.
+	for i, msg := range msgs {
+		if msg == nil {
+			return sdk.ErrInternal(fmt.Sprintf("Tx.GetMsgs()[%d] is nil", i)).Result()
+		}
+		err := msg.ValidateBasic()
+		if err != nil {
+			return err.Result()
+		}
+		if app.router.Route(msg.Type()) == nil {
+			errMsg := fmt.Sprintf("No route for msg type %v", msg.Type())
+			return sdk.ErrUnknownRequest(errMsg).Result()
+		}
+	}
+
+	// Filter out txs paying less than our minimum gas price.
+	// This runs before the ante handler so the CheckTx state isn't touched.
+	if mode == runTxModeCheck {
+		err := app.checkMinGasPrices(tx)
+		if err != nil {
+			return err.Result()
+		}
 	}
 
 	// TODO: override default ante handler w/ custom ante handler.
 
-	// Run the ante handler.
-	newCtx, result, abort := app.anteHandler(ctx, tx)
-	if isCheckTx || abort {
+	// Run the ante handler in a cache, written only if it doesn't abort,
+	// so that an invalid tx leaves no writes behind.
+	// Running out of gas panics, so anteCache is never written.
+	anteCache := ms.CacheMultiStore()
+	newCtx, result, abort := app.anteHandler(ctx.WithMultiStore(anteCache), tx, mode == runTxModeSimulate)
+	if abort {
 		return result
 	}
+	anteCache.Write()
 	if !newCtx.IsZero() {
-		ctx = newCtx
+		ctx = newCtx.WithMultiStore(ms)
+	}
+	if mode == runTxModeCheck {
+		return result
+	}
+
+	// Simulated msgs run directly against the throwaway cache.
+	if mode == runTxModeSimulate {
+		return app.runMsgs(ctx, msgs)
+	}
+
+	// Don't run the msg if it may exceed the block gas limit.
+	if app.blockGasLimit > 0 {
+		remaining := app.blockGasLimit - app.blockGasConsumed
+		if ctx.GasMeter().Limit() > remaining {
+			errMsg := fmt.Sprintf("Tx gas limit %v exceeds remaining block gas %v", ctx.GasMeter().Limit(), remaining)
+			return sdk.ErrOutOfGas(errMsg).Result()
+		}
 	}
 
 	// CacheWrap app.msDeliver in case it fails.
+	// Running out of gas panics, so msCache is never written.
 	msCache := app.msDeliver.CacheMultiStore()
 	ctx = ctx.WithMultiStore(msCache)
 
-	// Match and run route.
-	msgType := msg.Type()
-	handler := app.router.Route(msgType)
-	result = handler(ctx, msg)
+	result = app.runMsgs(ctx, msgs)
 
-	// If result was successful, write to app.msDeliver or app.msCheck.
+	// If all msgs were successful, write to app.msDeliver.
 	if result.IsOK() {
 		msCache.Write()
 	}
@@ -357,6 +613,84 @@ func (app *BaseApp) runTx(isCheckTx bool, txBytes []byte, tx sdk.Tx) (result sdk
 	return result
 }
 
+// Runs the msgs in order, stopping at the first failure.
+// On success, returns the results of all msgs merged together.
+// On failure, returns the result of the failed msg.
+func (app *BaseApp) runMsgs(ctx sdk.Context, msgs []sdk.Msg) (result sdk.Result) {
+	var data []byte
+	var logs []string
+	for i, msg := range msgs {
+
+		// Match and run route.
+		msgType := msg.Type()
+		handler := app.router.Route(msgType)
+		msgResult := handler(ctx, msg)
+
+		if !msgResult.IsOK() {
+			msgResult.Log = fmt.Sprintf("msg %d failed: %s", i, msgResult.Log)
+			return msgResult
+		}
+
+		data = append(data, msgResult.Data...)
+		if msgResult.Log != "" {
+			logs = append(logs, msgResult.Log)
+		}
+		result.ValidatorUpdates = append(result.ValidatorUpdates, msgResult.ValidatorUpdates...)
+		result.Tags = append(result.Tags, msgResult.Tags...)
+	}
+	result.Data = data
+	result.Log = strings.Join(logs, "\n")
+	return result
+}
+
+// Returns an error unless the fee of a StdTx covers its gas at the minimum
+// gas price of at least one of the denominations in app.minGasPrices.
+// Txs that are not StdTxs don't declare a fee, so they are not filtered.
+func (app *BaseApp) checkMinGasPrices(tx sdk.Tx) sdk.Error {
+	if app.minGasPrices.IsZero() {
+		return nil
+	}
+	stdTx, ok := tx.(sdk.StdTx)
+	if !ok {
+		return nil
+	}
+	fee := stdTx.Fee
+	if fee.Gas <= 0 {
+		errMsg := fmt.Sprintf("Gas %v can't pay the min gas prices %v", fee.Gas, app.minGasPrices)
+		return sdk.ErrInsufficientFee(errMsg)
+	}
+
+	// NOTE: the gas is chosen by the tx, so the product may overflow int64.
+	gas := big.NewInt(fee.Gas)
+	for _, price := range app.minGasPrices {
+		required := new(big.Int).Mul(big.NewInt(price.Amount), gas)
+		if big.NewInt(fee.Amount.AmountOf(price.Denom)).Cmp(required) >= 0 {
+			return nil
+		}
+	}
+	errMsg := fmt.Sprintf("Fee %v doesn't cover %v gas at min gas prices %v", fee.Amount, fee.Gas, app.minGasPrices)
+	return sdk.ErrInsufficientFee(errMsg)
+}
+
+// Returns the block gas limit set in the main store, or zero.
+func loadBlockGasLimit(main sdk.KVStore) sdk.Gas {
+	bz := main.Get(mainBlockGasLimitKey)
+	if bz == nil {
+		return 0
+	}
+	return sdk.Gas(binary.BigEndian.Uint64(bz))
+}
+
+// Adds the gas used by a DeliverTx to the block total.
+// A tx that ran out of gas is charged its full limit.
+func (app *BaseApp) consumeBlockGas(result sdk.Result) {
+	gasUsed := result.GasUsed
+	if result.GasWanted > 0 && gasUsed > result.GasWanted {
+		gasUsed = result.GasWanted
+	}
+	app.blockGasConsumed += gasUsed
+}
+
 // Implements ABCI
 func (app *BaseApp) EndBlock(req abci.RequestEndBlock) (res abci.ResponseEndBlock) {
 	if app.endBlocker != nil {
@@ -370,6 +704,12 @@ func (app *BaseApp) EndBlock(req abci.RequestEndBlock) (res abci.ResponseEndBloc
 // Implements ABCI
 func (app *BaseApp) Commit() (res abci.ResponseCommit) {
 	// Write the Deliver state and commit the MultiStore
+	// The chain ID goes into the Deliver state, so it's committed with it.
+	header := app.ctxDeliver.BlockHeader()
+	main := app.msDeliver.GetKVStore(app.mainKey)
+	if string(main.Get(mainChainIDKey)) != header.ChainID {
+		main.Set(mainChainIDKey, []byte(header.ChainID))
+	}
 	app.msDeliver.Write()
 	commitID := app.cms.Commit()
 	app.logger.Debug("Commit synced",
@@ -379,9 +719,7 @@ func (app *BaseApp) Commit() (res abci.ResponseCommit) {
 	// Reset the Check state
 	// NOTE: safe because Tendermint holds a lock on the mempool for Commit.
 	// Use the header from this latest block.
-	header := app.ctxDeliver.BlockHeader()
-	app.msCheck = app.cms.CacheMultiStore()
-	app.ctxCheck = app.NewContext(true, header)
+	app.setCheckState(header)
 
 	return abci.ResponseCommit{
 		Data: commitID.Hash,
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"os"
 	"testing"
 
@@ -121,7 +123,9 @@ func TestDeliverTx(t *testing.T) {
 
 	counter := 0
 	txPerHeight := 2
-	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx) (newCtx sdk.Context, res sdk.Result, abort bool) { return })
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
 	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		store := ctx.KVStore(capKey)
 		if counter > 0 {
@@ -172,7 +176,9 @@ func TestQuery(t *testing.T) {
 
 	key, value := []byte("hello"), []byte("goodbye")
 
-	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx) (newCtx sdk.Context, res sdk.Result, abort bool) { return })
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
 	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		store := ctx.KVStore(capKey)
 		store.Set(key, value)
@@ -207,6 +213,540 @@ func TestQuery(t *testing.T) {
 	assert.Equal(t, value, res.Value)
 }
 
+// Test that transient stores are shared by the txs of a block,
+// and are wiped when the block is committed.
+func TestTransientStore(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make cap keys and mount the stores
+	capKey := sdk.NewKVStoreKey("main")
+	tKey := sdk.NewTransientStoreKey("transient")
+	app.MountStoresIAVL(capKey)
+	app.MountStoresTransient(tKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	counterKey := []byte("counter")
+
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		store := ctx.KVStore(tKey)
+		counter := []byte{0}
+		if bz := store.Get(counterKey); bz != nil {
+			counter = bz
+		}
+		counter = []byte{counter[0] + 1}
+		store.Set(counterKey, counter)
+		return sdk.Result{Data: counter}
+	})
+
+	tx := testUpdatePowerTx{} // doesn't matter
+	for blockN := 0; blockN < 2; blockN++ {
+		app.BeginBlock(abci.RequestBeginBlock{})
+		for i := 1; i <= 3; i++ {
+			res := app.Deliver(tx)
+			assert.True(t, res.IsOK(), res.Log)
+			assert.Equal(t, []byte{byte(i)}, res.Data)
+		}
+		app.EndBlock(abci.RequestEndBlock{})
+		app.Commit()
+	}
+}
+
+// Test that the store operations of DeliverTxs are traced,
+// with their block height and tx index.
+func TestTracing(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	var buf bytes.Buffer
+	app.SetCommitMultiStoreTracer(&buf)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	txN := 0
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		ctx.KVStore(capKey).Set([]byte(fmt.Sprintf("tx%d", txN)), []byte("done"))
+		txN++
+		return sdk.Result{}
+	})
+
+	tx := testUpdatePowerTx{} // doesn't matter
+	app.BeginBlock(abci.RequestBeginBlock{Header: abci.Header{Height: 1}})
+	for i := 0; i < 2; i++ {
+		res := app.Deliver(tx)
+		assert.True(t, res.IsOK(), res.Log)
+	}
+	app.EndBlock(abci.RequestEndBlock{})
+	app.Commit()
+
+	// each tx's write is traced once, with its index,
+	// and not again when the block is committed
+	type traceOp struct {
+		Store     string
+		Operation string
+		Key       []byte
+		Value     []byte
+		Metadata  map[string]interface{}
+	}
+	txWrites := map[string]float64{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var op traceOp
+		err := json.Unmarshal(line, &op)
+		assert.Nil(t, err)
+		if op.Operation != "write" {
+			continue
+		}
+		assert.Equal(t, "main", op.Store)
+		assert.Equal(t, float64(1), op.Metadata["blockHeight"])
+		_, traced := txWrites[string(op.Key)]
+		assert.False(t, traced, "%s traced twice", op.Key)
+		txWrites[string(op.Key)] = op.Metadata["txIndex"].(float64)
+	}
+	assert.Equal(t, map[string]float64{"tx0": 0, "tx1": 1}, txWrites)
+}
+
+// Test that rolling back replays the same blocks to the same app hashes.
+func TestRollback(t *testing.T) {
+	db := dbm.NewMemDB()
+	capKey := sdk.NewKVStoreKey("main")
+	newApp := func() *BaseApp {
+		app := NewBaseApp(t.Name(), defaultLogger(), db)
+		app.MountStoresIAVL(capKey)
+		app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+			return
+		})
+		app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+			height := ctx.BlockHeight()
+			ctx.KVStore(capKey).Set([]byte(fmt.Sprintf("block%d", height)), []byte("done"))
+			return sdk.Result{}
+		})
+		return app
+	}
+	app := newApp()
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	runBlock := func(height int64) []byte {
+		app.BeginBlock(abci.RequestBeginBlock{Header: abci.Header{ChainID: "test-chain", Height: height}})
+		res := app.Deliver(testUpdatePowerTx{})
+		assert.True(t, res.IsOK(), res.Log)
+		app.EndBlock(abci.RequestEndBlock{})
+		return app.Commit().Data
+	}
+	hashes := [][]byte{}
+	for height := int64(1); height <= 3; height++ {
+		hashes = append(hashes, runBlock(height))
+	}
+
+	// roll back in a new app, as after a restart
+	app = newApp()
+	err = app.Rollback(1, capKey)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), app.LastBlockHeight())
+	assert.Equal(t, hashes[0], app.LastCommitID().Hash)
+
+	// the Check state has the chain ID of the block, to check the signatures
+	assert.Equal(t, "test-chain", app.ctxCheck.ChainID())
+	assert.Equal(t, hashes[1], runBlock(2))
+	assert.Equal(t, hashes[2], runBlock(3))
+}
+
+// Test that an app bootstrapped from a snapshot checks txs against the
+// chain ID of the snapshotted block.
+func TestSnapshotChainID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the stores are mounted on the app db
+	capKey := sdk.NewKVStoreKey("main")
+	app := newBaseApp(t.Name())
+	app.MountStoresIAVL(capKey)
+	err = app.LoadLatestVersion(capKey)
+	assert.Nil(t, err)
+	app.BeginBlock(abci.RequestBeginBlock{Header: abci.Header{ChainID: "test-chain", Height: 1}})
+	app.EndBlock(abci.RequestEndBlock{})
+	app.Commit()
+
+	commitID, err := app.ExportSnapshot(dir)
+	assert.Nil(t, err)
+
+	app2 := newBaseApp(t.Name())
+	app2.MountStoresIAVL(capKey)
+	commitID2, err := app2.ImportSnapshot(dir, capKey)
+	assert.Nil(t, err)
+	assert.Equal(t, commitID, commitID2)
+	assert.Equal(t, "test-chain", app2.ctxCheck.ChainID())
+}
+
+// Test that custom queries are routed to their querier,
+// and run read-only against the committed state at the requested height.
+func TestQueryCustom(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	key, value := []byte("hello"), []byte("goodbye")
+
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		ctx.KVStore(capKey).Set(key, value)
+		return sdk.Result{}
+	})
+	app.QueryRouter().AddRoute("test", func(ctx sdk.Context, path []string, req abci.RequestQuery) ([]byte, sdk.Error) {
+		assert.Equal(t, []string{"get", "value"}, path)
+		store := ctx.KVStore(capKey)
+		res := store.Get(req.Data)
+		store.Set(req.Data, []byte("overwritten")) // discarded
+		return res, nil
+	})
+
+	query := abci.RequestQuery{
+		Path: "/custom/test/get/value",
+		Data: key,
+	}
+
+	// nothing is committed yet
+	res := app.Query(query)
+	assert.Equal(t, uint32(sdk.CodeOK), res.Code, res.Log)
+	assert.Equal(t, 0, len(res.Value))
+
+	// the query sees the committed value, and can't change it
+	app.BeginBlock(abci.RequestBeginBlock{})
+	app.Deliver(testUpdatePowerTx{})
+	app.Commit()
+	for i := 0; i < 2; i++ {
+		res = app.Query(query)
+		assert.Equal(t, uint32(sdk.CodeOK), res.Code, res.Log)
+		assert.Equal(t, value, res.Value)
+		assert.Equal(t, app.LastBlockHeight(), res.Height)
+	}
+
+	// past heights can still be queried
+	oldValue := value
+	value = []byte("farewell")
+	app.BeginBlock(abci.RequestBeginBlock{})
+	app.Deliver(testUpdatePowerTx{})
+	app.Commit()
+	res = app.Query(query)
+	assert.Equal(t, value, res.Value)
+	query.Height = 1
+	res = app.Query(query)
+	assert.Equal(t, uint32(sdk.CodeOK), res.Code, res.Log)
+	assert.Equal(t, oldValue, res.Value)
+	assert.Equal(t, int64(1), res.Height)
+
+	// but not future ones
+	query.Height = 3
+	res = app.Query(query)
+	assert.Equal(t, uint32(sdk.CodeUnknownRequest), res.Code, res.Log)
+
+	// unknown routes are rejected
+	res = app.Query(abci.RequestQuery{Path: "/custom/nope/get"})
+	assert.Equal(t, uint32(sdk.CodeUnknownRequest), res.Code, res.Log)
+
+	// a panicking querier fails the query only
+	app.QueryRouter().AddRoute("panic", func(ctx sdk.Context, path []string, req abci.RequestQuery) ([]byte, sdk.Error) {
+		panic("bad query")
+	})
+	res = app.Query(abci.RequestQuery{Path: "/custom/panic/get"})
+	assert.Equal(t, uint32(sdk.CodeInternal), res.Code, res.Log)
+	assert.Equal(t, app.LastBlockHeight(), res.Height)
+}
+
+// Test that transactions exceeding the gas limit are aborted
+// and that their writes are reverted.
+func TestTxGasLimits(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	key, value := []byte("hello"), []byte("goodbye")
+	gasToConsume := sdk.Gas(0)
+
+	app.SetTxGasLimit(100)
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		store := ctx.KVStore(capKey)
+		store.Set(key, value)
+		ctx.GasMeter().ConsumeGas(gasToConsume, "test")
+		return sdk.Result{}
+	})
+
+	tx := testUpdatePowerTx{} // doesn't matter
+	app.BeginBlock(abci.RequestBeginBlock{})
+
+	// exceeds the limit, nothing is written
+	gasToConsume = 100
+	res := app.Deliver(tx)
+	assert.Equal(t, sdk.CodeOutOfGas, res.Code, res.Log)
+	assert.Equal(t, sdk.Gas(100), res.GasWanted)
+	assert.True(t, res.GasUsed > res.GasWanted)
+	ctx := app.NewContext(false, abci.Header{})
+	assert.Nil(t, ctx.KVStore(capKey).Get(key))
+
+	// within the limit, gas used is reported
+	gasToConsume = 10
+	res = app.Deliver(tx)
+	assert.True(t, res.IsOK(), res.Log)
+	assert.Equal(t, sdk.Gas(100), res.GasWanted)
+	assert.True(t, res.GasUsed >= gasToConsume)
+	assert.True(t, res.GasUsed <= res.GasWanted)
+	assert.Equal(t, value, ctx.KVStore(capKey).Get(key))
+}
+
+// Test that the writes of an aborted ante handler are dropped.
+func TestAnteHandlerAbort(t *testing.T) {
+	app := newBaseApp(t.Name())
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey)
+	assert.Nil(t, err)
+
+	key := []byte("fee")
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		// e.g. a fee deducted before a signature check fails
+		ctx.KVStore(capKey).Set(key, []byte("paid"))
+		return ctx, sdk.ErrUnauthorized("").Result(), true
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		return sdk.Result{}
+	})
+
+	res := app.Check(testUpdatePowerTx{})
+	assert.Equal(t, sdk.CodeUnauthorized, res.Code, res.Log)
+	assert.Nil(t, app.ctxCheck.KVStore(capKey).Get(key))
+
+	app.BeginBlock(abci.RequestBeginBlock{})
+	res = app.Deliver(testUpdatePowerTx{})
+	assert.Equal(t, sdk.CodeUnauthorized, res.Code, res.Log)
+	app.EndBlock(abci.RequestEndBlock{})
+	app.Commit()
+	assert.Nil(t, app.cms.GetKVStore(capKey).Get(key))
+}
+
+// Test that DeliverTx is rejected once the block gas limit is reached,
+// and that the limit resets on the next block.
+func TestBlockGasLimit(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	// the block gas limit is part of the genesis state
+	app.SetInitChainer(func(ctx sdk.Context, req abci.RequestInitChain) abci.ResponseInitChain {
+		app.SetBlockGasLimit(ctx, 250)
+		return abci.ResponseInitChain{}
+	})
+	app.InitChain(abci.RequestInitChain{})
+	assert.Equal(t, sdk.Gas(250), app.BlockGasLimit(app.NewContext(true, abci.Header{})))
+
+	app.SetTxGasLimit(100)
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		ctx.GasMeter().ConsumeGas(60, "test")
+		return sdk.Result{}
+	})
+
+	tx := testUpdatePowerTx{} // doesn't matter
+
+	for blockN := 0; blockN < 2; blockN++ {
+		app.BeginBlock(abci.RequestBeginBlock{})
+
+		// 3 txs fit, as each leaves at least 100 gas in the block
+		for i := 0; i < 3; i++ {
+			res := app.Deliver(tx)
+			assert.True(t, res.IsOK(), res.Log)
+			assert.Equal(t, sdk.Gas(60), res.GasUsed)
+		}
+
+		// the 4th might exceed the remaining 70 gas
+		res := app.Deliver(tx)
+		assert.Equal(t, sdk.CodeOutOfGas, res.Code, res.Log)
+
+		// CheckTx is not limited by the block
+		res = app.Check(tx)
+		assert.True(t, res.IsOK(), res.Log)
+
+		app.EndBlock(abci.RequestEndBlock{})
+		app.Commit()
+	}
+}
+
+// Test that CheckTx filters txs below the min gas price,
+// while DeliverTx ignores it.
+func TestMinGasPrices(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	app.SetMinGasPrices(sdk.Coins{{"atom", 2}, {"photon", 1}})
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		return sdk.Result{}
+	})
+
+	msg := testUpdatePowerTx{} // doesn't matter
+	msgs := []sdk.Msg{msg}
+	cheapTx := sdk.NewStdTx(msgs, sdk.NewStdFee(100, sdk.Coin{"atom", 199}), nil)
+	atomTx := sdk.NewStdTx(msgs, sdk.NewStdFee(100, sdk.Coin{"atom", 200}), nil)
+	photonTx := sdk.NewStdTx(msgs, sdk.NewStdFee(100, sdk.Coin{"photon", 100}), nil)
+
+	res := app.Check(cheapTx)
+	assert.Equal(t, sdk.CodeInsufficientFee, res.Code, res.Log)
+	res = app.Check(atomTx)
+	assert.True(t, res.IsOK(), res.Log)
+	res = app.Check(photonTx)
+	assert.True(t, res.IsOK(), res.Log)
+
+	// the gas can't be negative, nor overflow the required fee
+	negativeTx := sdk.NewStdTx(msgs, sdk.NewStdFee(-100), nil)
+	res = app.Check(negativeTx)
+	assert.Equal(t, sdk.CodeInsufficientFee, res.Code, res.Log)
+	overflowTx := sdk.NewStdTx(msgs, sdk.NewStdFee(math.MaxInt64/2+1), nil)
+	res = app.Check(overflowTx)
+	assert.Equal(t, sdk.CodeInsufficientFee, res.Code, res.Log)
+
+	// consensus doesn't depend on the local min gas price
+	app.BeginBlock(abci.RequestBeginBlock{})
+	res = app.Deliver(cheapTx)
+	assert.True(t, res.IsOK(), res.Log)
+}
+
+// Test that the msgs of a tx are run in order,
+// and that a failing msg reverts all the msgs before it.
+func TestMultiMsgDeliverTx(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		powerMsg := msg.(testUpdatePowerTx)
+		if powerMsg.NewPower < 0 {
+			return sdk.ErrUnknownRequest("negative power").Result()
+		}
+		ctx.KVStore(capKey).Set(powerMsg.Addr, []byte{byte(powerMsg.NewPower)})
+		return sdk.Result{Data: powerMsg.Addr}
+	})
+
+	msg1 := testUpdatePowerTx{Addr: []byte("val1"), NewPower: 1}
+	msg2 := testUpdatePowerTx{Addr: []byte("val2"), NewPower: 2}
+	badMsg := testUpdatePowerTx{Addr: []byte("val3"), NewPower: -1}
+	fee := sdk.NewStdFee(0)
+
+	app.BeginBlock(abci.RequestBeginBlock{})
+	ctx := app.NewContext(false, abci.Header{})
+	store := ctx.KVStore(capKey)
+
+	// the second msg fails, so the first is reverted
+	res := app.Deliver(sdk.NewStdTx([]sdk.Msg{msg1, badMsg}, fee, nil))
+	assert.Equal(t, sdk.CodeUnknownRequest, res.Code, res.Log)
+	assert.Nil(t, store.Get(msg1.Addr))
+
+	// all msgs succeed and are written
+	res = app.Deliver(sdk.NewStdTx([]sdk.Msg{msg1, msg2}, fee, nil))
+	assert.True(t, res.IsOK(), res.Log)
+	assert.Equal(t, []byte("val1val2"), res.Data)
+	assert.Equal(t, []byte{1}, store.Get(msg1.Addr))
+	assert.Equal(t, []byte{2}, store.Get(msg2.Addr))
+
+	// a tx without msgs is rejected
+	res = app.Deliver(sdk.NewStdTx(nil, fee, nil))
+	assert.Equal(t, sdk.CodeTxParse, res.Code, res.Log)
+}
+
+// Test that simulating a tx reports the gas it uses,
+// both directly and via the "/app/simulate" query,
+// without writing to the check or deliver state.
+func TestSimulateTx(t *testing.T) {
+	app := newBaseApp(t.Name())
+
+	// make a cap key and mount the store
+	capKey := sdk.NewKVStoreKey("main")
+	app.MountStoresIAVL(capKey)
+	err := app.LoadLatestVersion(capKey) // needed to make stores non-nil
+	assert.Nil(t, err)
+
+	key, value := []byte("hello"), []byte("goodbye")
+	gasConsumed := sdk.Gas(5000)
+
+	// the simulated tx may use more gas than the tx gas limit
+	app.SetTxGasLimit(100)
+	app.SetTxDecoder(func(txBytes []byte) (sdk.Tx, sdk.Error) {
+		var ttx testUpdatePowerTx
+		fromJSON(txBytes, &ttx)
+		return ttx, nil
+	})
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		assert.True(t, simulate)
+		return
+	})
+	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
+		ctx.KVStore(capKey).Set(key, value)
+		ctx.GasMeter().ConsumeGas(gasConsumed, "test")
+		return sdk.Result{}
+	})
+
+	tx := testUpdatePowerTx{} // doesn't matter
+	app.BeginBlock(abci.RequestBeginBlock{})
+
+	res := app.Simulate(tx)
+	assert.True(t, res.IsOK(), res.Log)
+	assert.True(t, res.GasUsed > gasConsumed)
+
+	query := abci.RequestQuery{
+		Path: "/app/simulate",
+		Data: toJSON(tx),
+	}
+	queryRes := app.Query(query)
+	assert.Equal(t, uint32(sdk.CodeOK), queryRes.Code, queryRes.Log)
+	var simRes sdk.Result
+	fromJSON(queryRes.Value, &simRes)
+	assert.Equal(t, res.GasUsed, simRes.GasUsed)
+
+	// nothing was written
+	assert.Nil(t, app.NewContext(true, abci.Header{}).KVStore(capKey).Get(key))
+	assert.Nil(t, app.NewContext(false, abci.Header{}).KVStore(capKey).Get(key))
+}
+
 //----------------------
 // TODO: clean this up
 
@@ -220,7 +760,7 @@ const msgType = "testUpdatePowerTx"
 
 func (tx testUpdatePowerTx) Type() string                            { return msgType }
 func (tx testUpdatePowerTx) Get(key interface{}) (value interface{}) { return nil }
-func (tx testUpdatePowerTx) GetMsg() sdk.Msg                         { return tx }
+func (tx testUpdatePowerTx) GetMsgs() []sdk.Msg                      { return []sdk.Msg{tx} }
 func (tx testUpdatePowerTx) GetSignBytes() []byte                    { return nil }
 func (tx testUpdatePowerTx) ValidateBasic() sdk.Error                { return nil }
 func (tx testUpdatePowerTx) GetSigners() []crypto.Address            { return nil }
@@ -238,7 +778,9 @@ func TestValidatorChange(t *testing.T) {
 		return ttx, nil
 	})
 
-	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx) (newCtx sdk.Context, res sdk.Result, abort bool) { return })
+	app.SetAnteHandler(func(ctx sdk.Context, tx sdk.Tx, simulate bool) (newCtx sdk.Context, res sdk.Result, abort bool) {
+		return
+	})
 	app.Router().AddRoute(msgType, func(ctx sdk.Context, msg sdk.Msg) sdk.Result {
 		// TODO
 		return sdk.Result{}
@@ -1,54 +1,105 @@
 package baseapp
 
-/*
-	XXX Make this work with MultiStore.
-	XXX It will require some interfaces updates in store/types.go.
-
-	if len(reqQuery.Data) == 0 {
-		resQuery.Log = "Query cannot be zero length"
-		resQuery.Code = abci.CodeType_EncodingError
-		return
-	}
-
-	// set the query response height to current
-	tree := app.state.Committed()
-
-	height := reqQuery.Height
-	if height == 0 {
-		// TODO: once the rpc actually passes in non-zero
-		// heights we can use to query right after a tx
-		// we must retrun most recent, even if apphash
-		// is not yet in the blockchain
-
-		withProof := app.CommittedHeight() - 1
-		if tree.Tree.VersionExists(withProof) {
-			height = withProof
-		} else {
-			height = app.CommittedHeight()
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"strings"
+
+	abci "github.com/tendermint/abci/types"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Implements ABCI.
+// Paths starting with "/app/" are handled by the BaseApp,
+// and paths starting with "/custom/" by the queriers of the QueryRouter.
+// Anything else is delegated to the CommitMultiStore if it implements Queryable.
+func (app *BaseApp) Query(req abci.RequestQuery) (res abci.ResponseQuery) {
+	path := strings.Split(strings.TrimPrefix(req.Path, "/"), "/")
+	if len(path) >= 2 {
+		switch path[0] {
+		case "app":
+			return app.queryApp(path[1:], req)
+		case "custom":
+			return app.queryCustom(path[1:], req)
 		}
 	}
-	resQuery.Height = height
-
-	switch reqQuery.Path {
-	case "/store", "/key": // Get by key
-		key := reqQuery.Data // Data holds the key bytes
-		resQuery.Key = key
-		if reqQuery.Prove {
-			value, proof, err := tree.GetVersionedWithProof(key, height)
-			if err != nil {
-				resQuery.Log = err.Error()
-				break
-			}
-			resQuery.Value = value
-			resQuery.Proof = proof.Bytes()
-		} else {
-			value := tree.Get(key)
-			resQuery.Value = value
-		}
 
+	queryable, ok := app.cms.(sdk.Queryable)
+	if !ok {
+		msg := "application doesn't support queries"
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+	return queryable.Query(req)
+}
+
+// Handles the "/app/<path>" queries.
+func (app *BaseApp) queryApp(path []string, req abci.RequestQuery) (res abci.ResponseQuery) {
+	switch path[0] {
+	case "simulate":
+		// Data holds the tx bytes, Value the JSON encoded Result.
+		tx, err := app.txDecoder(req.Data)
+		if err != nil {
+			return err.Result().ToQuery()
+		}
+		result := app.Simulate(tx)
+		bz, jsonErr := json.Marshal(result)
+		if jsonErr != nil {
+			return sdk.ErrInternal(jsonErr.Error()).Result().ToQuery()
+		}
+		return abci.ResponseQuery{
+			Code:  uint32(sdk.CodeOK),
+			Value: bz,
+		}
 	default:
-		resQuery.Code = abci.CodeType_UnknownRequest
-		resQuery.Log = cmn.Fmt("Unexpected Query path: %v", reqQuery.Path)
+		msg := fmt.Sprintf("Unknown app query path %v", req.Path)
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+}
+
+// Handles the "/custom/<route>/<path...>" queries.
+func (app *BaseApp) queryCustom(path []string, req abci.RequestQuery) (res abci.ResponseQuery) {
+	querier := app.queryRouter.Route(path[0])
+	if querier == nil {
+		msg := fmt.Sprintf("No querier for custom query route %v", path[0])
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+
+	// Cache-wrap the committed state, and never write the cache,
+	// so that the querier can't change it.
+	// Queries default to the latest committed height.
+	height := app.LastBlockHeight()
+	header := app.ctxCheck.BlockHeader()
+	if req.Height != 0 && req.Height != height {
+		height = req.Height
+		header = abci.Header{Height: height} // NOTE: old headers aren't stored
+	}
+	ms, err := app.cms.CacheMultiStoreWithVersion(height)
+	if err != nil {
+		msg := fmt.Sprintf("Can't query height %v: %v", height, err)
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+	ctx := sdk.NewContext(ms, header, true, nil)
+
+	// Handle any panics of the querier, e.g. on a malformed query.
+	defer func() {
+		if r := recover(); r != nil {
+			log := fmt.Sprintf("Recovered: %v\nstack:\n%v", r, string(debug.Stack()))
+			res = sdk.ErrInternal(log).Result().ToQuery()
+			res.Height = height
+		}
+	}()
+
+	bz, qErr := querier(ctx, path[1:], req)
+	if qErr != nil {
+		res = qErr.Result().ToQuery()
+		res.Height = height
+		return res
+	}
+	return abci.ResponseQuery{
+		Code:   uint32(sdk.CodeOK),
+		Value:  bz,
+		Height: height,
 	}
-	return
-*/
+}
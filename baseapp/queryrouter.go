@@ -0,0 +1,47 @@
+package baseapp
+
+import (
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// QueryRouter provides queriers for each query path prefix.
+type QueryRouter interface {
+	AddRoute(r string, q sdk.Querier)
+	Route(path string) (q sdk.Querier)
+}
+
+// map a query path prefix to a querier
+type queryRoute struct {
+	r string
+	q sdk.Querier
+}
+
+type queryRouter struct {
+	routes []queryRoute
+}
+
+// nolint
+// NewQueryRouter - create new query router
+func NewQueryRouter() *queryRouter {
+	return &queryRouter{
+		routes: make([]queryRoute, 0),
+	}
+}
+
+// AddRoute registers the querier for "/custom/<r>/..." queries.
+func (rtr *queryRouter) AddRoute(r string, q sdk.Querier) {
+	if !isAlpha(r) {
+		panic("route expressions can only contain alphanumeric characters")
+	}
+	rtr.routes = append(rtr.routes, queryRoute{r, q})
+}
+
+// Route returns the querier registered for r, or nil.
+func (rtr *queryRouter) Route(path string) (q sdk.Querier) {
+	for _, route := range rtr.routes {
+		if route.r == path {
+			return route.q
+		}
+	}
+	return nil
+}
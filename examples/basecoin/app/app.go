@@ -2,6 +2,7 @@ package app
 
 import (
 	"encoding/json"
+	"fmt"
 
 	abci "github.com/tendermint/abci/types"
 	crypto "github.com/tendermint/go-crypto"
@@ -56,9 +57,13 @@ func NewBasecoinApp(logger log.Logger, db dbm.DB) *BasecoinApp {
 	app.Router().AddRoute("bank", bank.NewHandler(coinKeeper))
 	app.Router().AddRoute("sketchy", sketchy.NewHandler())
 
+	// add queriers
+	app.QueryRouter().AddRoute("bank", bank.NewQuerier(coinKeeper))
+
 	// initialize BaseApp
 	app.SetTxDecoder(app.txDecoder)
 	app.SetInitChainer(app.initChainer)
+	app.SetAppStateExporter(app.exportAppStateJSON)
 	app.MountStoresIAVL(app.capKeyMainStore, app.capKeyIBCStore)
 	app.SetAnteHandler(auth.NewAnteHandler(app.accountMapper))
 	err := app.LoadLatestVersion(app.capKeyMainStore)
@@ -80,7 +85,7 @@ func MakeTxCodec() *wire.Codec {
 // custom logic for transaction decoding
 func (app *BasecoinApp) txDecoder(txBytes []byte) (sdk.Tx, sdk.Error) {
 	var tx = sdk.StdTx{}
-	// StdTx.Msg is an interface. The concrete types
+	// StdTx.Msgs holds interfaces. The concrete types
 	// are registered by MakeTxCodec in bank.RegisterWire.
 	err := app.cdc.UnmarshalBinary(txBytes, &tx)
 	if err != nil {
@@ -100,13 +105,42 @@ func (app *BasecoinApp) initChainer(ctx sdk.Context, req abci.RequestInitChain)
 		// return sdk.ErrGenesisParse("").TraceCause(err, "")
 	}
 
+	app.SetBlockGasLimit(ctx, genesisState.BlockGasLimit)
+
+	// The accounts are numbered in the order of the genesis.
 	for _, gacc := range genesisState.Accounts {
 		acc, err := gacc.ToAppAccount()
 		if err != nil {
 			panic(err) // TODO https://github.com/cosmos/cosmos-sdk/issues/468
 			//	return sdk.ErrGenesisParse("").TraceCause(err, "")
 		}
+		acc.SetAccountNumber(app.accountMapper.GetNextAccountNumber(ctx))
 		app.accountMapper.SetAccount(ctx, acc)
 	}
 	return abci.ResponseInitChain{}
 }
+
+// custom logic for basecoin genesis export, the inverse of initChainer
+// NOTE: the sequences are exported, so the txs already run can't run
+// again, but the account numbers are assigned anew by the initChainer,
+// so the chain must restart with a new chain ID.
+func (app *BasecoinApp) exportAppStateJSON(ctx sdk.Context) (json.RawMessage, error) {
+	genesisState := types.GenesisState{
+		Accounts:      []*types.GenesisAccount{},
+		BlockGasLimit: app.BlockGasLimit(ctx),
+	}
+	var err error
+	app.accountMapper.IterateAccounts(ctx, func(acc sdk.Account) bool {
+		appAcc, ok := acc.(*types.AppAccount)
+		if !ok {
+			err = fmt.Errorf("Unexpected account type %T", acc)
+			return true
+		}
+		genesisState.Accounts = append(genesisState.Accounts, types.NewGenesisAccount(appAcc))
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(genesisState, "", "\t")
+}
@@ -47,8 +47,9 @@ func TestSendMsg(t *testing.T) {
 	}
 
 	priv := crypto.GenPrivKeyEd25519()
-	sig := priv.Sign(msg.GetSignBytes())
-	tx := sdk.NewStdTx(msg, []sdk.StdSignature{{
+	fee := sdk.NewStdFee(100000)
+	sig := priv.Sign(sdk.StdSignBytes("", []int64{0}, []int64{0}, fee, []sdk.Msg{msg}))
+	tx := sdk.NewStdTx([]sdk.Msg{msg}, fee, []sdk.StdSignature{{
 		PubKey:    priv.PubKey(),
 		Signature: sig,
 	}})
@@ -94,6 +95,36 @@ func TestGenesis(t *testing.T) {
 	assert.Equal(t, acc, res1)
 }
 
+func TestExportGenesis(t *testing.T) {
+	bapp := newBasecoinApp()
+
+	// Two accounts, in order of addresses
+	coins, err := sdk.ParseCoins("77foocoin,99barcoin")
+	require.Nil(t, err)
+	genesisState := types.GenesisState{
+		Accounts: []*types.GenesisAccount{
+			{Name: "foo", Address: crypto.Address([]byte("addr1")), Coins: coins},
+			{Name: "bar", Address: crypto.Address([]byte("addr2")), Coins: coins, Sequence: 7},
+		},
+		BlockGasLimit: 1000000,
+	}
+	stateBytes, err := json.MarshalIndent(genesisState, "", "\t")
+	require.Nil(t, err)
+	bapp.InitChain(abci.RequestInitChain{[]abci.Validator{}, stateBytes})
+
+	// The latest state is the genesis state, with the sequences
+	exported, err := bapp.ExportAppStateJSON(0)
+	require.Nil(t, err)
+	assert.Equal(t, string(stateBytes), string(exported))
+	exported, err = bapp.ExportAppStateJSON(1)
+	require.Nil(t, err)
+	assert.Equal(t, string(stateBytes), string(exported))
+
+	// Heights that weren't committed can't be exported
+	_, err = bapp.ExportAppStateJSON(2)
+	assert.NotNil(t, err)
+}
+
 func TestSendMsgWithAccounts(t *testing.T) {
 	bapp := newBasecoinApp()
 
@@ -152,8 +183,9 @@ func TestSendMsgWithAccounts(t *testing.T) {
 	}
 
 	// Sign the tx
-	sig := priv1.Sign(msg.GetSignBytes())
-	tx := sdk.NewStdTx(msg, []sdk.StdSignature{{
+	fee := sdk.NewStdFee(100000, sdk.Coin{"foocoin", 2})
+	sig := priv1.Sign(sdk.StdSignBytes("", []int64{0}, []int64{0}, fee, []sdk.Msg{msg}))
+	tx := sdk.NewStdTx([]sdk.Msg{msg}, fee, []sdk.StdSignature{{
 		PubKey:    priv1.PubKey(),
 		Signature: sig,
 	}})
@@ -161,6 +193,62 @@ func TestSendMsgWithAccounts(t *testing.T) {
 	// Run a Check
 	res := bapp.Check(tx)
 	assert.Equal(t, sdk.CodeOK, res.Code, res.Log)
+	assert.Equal(t, int64(2), res.FeeAmount)
+	assert.Equal(t, "foocoin", res.FeeDenom)
+
+	// A fee larger than the balance is rejected
+	bigFee := sdk.NewStdFee(100000, sdk.Coin{"foocoin", 1000})
+	bigSig := priv1.Sign(sdk.StdSignBytes("", []int64{0}, []int64{1}, bigFee, []sdk.Msg{msg}))
+	bigTx := sdk.NewStdTx([]sdk.Msg{msg}, bigFee, []sdk.StdSignature{{
+		PubKey:    priv1.PubKey(),
+		Signature: bigSig,
+		Sequence:  1,
+	}})
+	res = bapp.Check(bigTx)
+	assert.Equal(t, sdk.CodeInsufficientFunds, res.Code, res.Log)
+
+	// A simulated tx needs no valid signature, and reports its gas
+	simTx := sdk.NewStdTx([]sdk.Msg{msg}, fee, []sdk.StdSignature{{
+		PubKey:   priv1.PubKey(),
+		Sequence: 1,
+	}})
+	res = bapp.Simulate(simTx)
+	assert.Equal(t, sdk.CodeOK, res.Code, res.Log)
+	assert.True(t, res.GasUsed > 0)
+	res = bapp.Check(simTx)
+	assert.Equal(t, sdk.CodeUnauthorized, res.Code, res.Log)
+
+	// A tx signed for another chain is rejected
+	otherChainSig := priv1.Sign(sdk.StdSignBytes("other-chain", []int64{0}, []int64{1}, fee, []sdk.Msg{msg}))
+	otherChainTx := sdk.NewStdTx([]sdk.Msg{msg}, fee, []sdk.StdSignature{{
+		PubKey:    priv1.PubKey(),
+		Signature: otherChainSig,
+		Sequence:  1,
+	}})
+	res = bapp.Check(otherChainTx)
+	assert.Equal(t, sdk.CodeUnauthorized, res.Code, res.Log)
+
+	// A tx signed for another account number is rejected
+	otherNumSig := priv1.Sign(sdk.StdSignBytes("", []int64{1}, []int64{1}, fee, []sdk.Msg{msg}))
+	otherNumTx := sdk.NewStdTx([]sdk.Msg{msg}, fee, []sdk.StdSignature{{
+		PubKey:        priv1.PubKey(),
+		Signature:     otherNumSig,
+		AccountNumber: 1,
+		Sequence:      1,
+	}})
+	res = bapp.Check(otherNumTx)
+	assert.Equal(t, sdk.CodeUnauthorized, res.Code, res.Log)
+
+	// A tx signed with another key is rejected, even with its pubkey
+	priv3 := crypto.GenPrivKeyEd25519()
+	otherKeySig := priv3.Sign(sdk.StdSignBytes("", []int64{0}, []int64{1}, fee, []sdk.Msg{msg}))
+	otherKeyTx := sdk.NewStdTx([]sdk.Msg{msg}, fee, []sdk.StdSignature{{
+		PubKey:    priv3.PubKey(),
+		Signature: otherKeySig,
+		Sequence:  1,
+	}})
+	res = bapp.Check(otherKeyTx)
+	assert.Equal(t, sdk.CodeUnauthorized, res.Code, res.Log)
 
 	// Simulate a Block
 	bapp.BeginBlock(abci.RequestBeginBlock{})
@@ -174,6 +262,20 @@ func TestSendMsgWithAccounts(t *testing.T) {
 	res2 := bapp.accountMapper.GetAccount(ctxDeliver, addr1)
 	res3 := bapp.accountMapper.GetAccount(ctxDeliver, addr2)
 
-	assert.Equal(t, fmt.Sprintf("%v", res2.GetCoins()), "67foocoin")
+	assert.Equal(t, fmt.Sprintf("%v", res2.GetCoins()), "65foocoin")
 	assert.Equal(t, fmt.Sprintf("%v", res3.GetCoins()), "10foocoin")
+
+	// Query the balance once the block is committed
+	bapp.EndBlock(abci.RequestEndBlock{})
+	bapp.Commit()
+	query := abci.RequestQuery{
+		Path: "/custom/bank/balance",
+		Data: addr2,
+	}
+	qres := bapp.Query(query)
+	require.Equal(t, uint32(sdk.CodeOK), qres.Code, qres.Log)
+	var coins sdk.Coins
+	err = json.Unmarshal(qres.Value, &coins)
+	require.Nil(t, err)
+	assert.Equal(t, sdk.Coins{{"foocoin", 10}}, coins)
 }
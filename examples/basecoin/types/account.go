@@ -26,29 +26,34 @@ func (acc *AppAccount) SetName(name string) { acc.Name = name }
 
 // State to Unmarshal
 type GenesisState struct {
-	Accounts []*GenesisAccount `json:"accounts"`
+	Accounts      []*GenesisAccount `json:"accounts"`
+	BlockGasLimit sdk.Gas           `json:"block_gas_limit"` // zero means no limit
 }
 
-// GenesisAccount doesn't need pubkey or sequence
+// GenesisAccount doesn't need pubkey.  The sequence is only set for the
+// accounts of an exported state, so that their txs can't run again.
 type GenesisAccount struct {
-	Name    string         `json:"name"`
-	Address crypto.Address `json:"address"`
-	Coins   sdk.Coins      `json:"coins"`
+	Name     string         `json:"name"`
+	Address  crypto.Address `json:"address"`
+	Coins    sdk.Coins      `json:"coins"`
+	Sequence int64          `json:"sequence"`
 }
 
 func NewGenesisAccount(aa *AppAccount) *GenesisAccount {
 	return &GenesisAccount{
-		Name:    aa.Name,
-		Address: aa.Address,
-		Coins:   aa.Coins,
+		Name:     aa.Name,
+		Address:  aa.Address,
+		Coins:    aa.Coins,
+		Sequence: aa.Sequence,
 	}
 }
 
 // convert GenesisAccount to AppAccount
 func (ga *GenesisAccount) ToAppAccount() (acc *AppAccount, err error) {
 	baseAcc := auth.BaseAccount{
-		Address: ga.Address,
-		Coins:   ga.Coins,
+		Address:  ga.Address,
+		Coins:    ga.Coins,
+		Sequence: ga.Sequence,
 	}
 	return &AppAccount{
 		BaseAccount: baseAcc,
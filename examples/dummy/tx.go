@@ -31,8 +31,8 @@ func (tx dummyTx) Type() string {
 	return "dummy"
 }
 
-func (tx dummyTx) GetMsg() sdk.Msg {
-	return tx
+func (tx dummyTx) GetMsgs() []sdk.Msg {
+	return []sdk.Msg{tx}
 }
 
 func (tx dummyTx) GetSignBytes() []byte {
@@ -1,11 +1,7 @@
 package store
 
 import (
-	"bytes"
-	"sort"
 	"sync"
-
-	cmn "github.com/tendermint/tmlibs/common"
 )
 
 // If value is nil but deleted is false, it means the parent doesn't have the
@@ -17,10 +13,12 @@ type cValue struct {
 }
 
 // cacheKVStore wraps an in-memory cache around an underlying KVStore.
+// The dirty keys are also kept sorted, for iteration and Write.
 type cacheKVStore struct {
-	mtx    sync.Mutex
-	cache  map[string]cValue
-	parent KVStore
+	mtx       sync.Mutex
+	cache     map[string]cValue
+	dirtyKeys *skipList
+	parent    KVStore
 }
 
 var _ CacheKVStore = (*cacheKVStore)(nil)
@@ -28,8 +26,9 @@ var _ CacheKVStore = (*cacheKVStore)(nil)
 func NewCacheKVStore(parent KVStore) *cacheKVStore {
 
 	ci := &cacheKVStore{
-		cache:  make(map[string]cValue),
-		parent: parent,
+		cache:     make(map[string]cValue),
+		dirtyKeys: newSkipList(),
+		parent:    parent,
 	}
 
 	return ci
@@ -83,34 +82,37 @@ func (ci *cacheKVStore) Delete(key []byte) {
 
 // Implements CacheKVStore.
 func (ci *cacheKVStore) Write() {
+	ci.write(ci.parent.Set, ci.parent.Delete)
+}
+
+// Writes the dirty keys with set and del, and clears the cache.
+func (ci *cacheKVStore) write(set func(key, value []byte), del func(key []byte)) {
 	ci.mtx.Lock()
 	defer ci.mtx.Unlock()
 
-	// We need a copy of all of the keys.
-	// Not the best, but probably not a bottleneck depending.
-	keys := make([]string, 0, len(ci.cache))
-	for key, dbValue := range ci.cache {
-		if dbValue.dirty {
-			keys = append(keys, key)
-		}
-	}
-	sort.Strings(keys)
-
 	// TODO: Consider allowing usage of Batch, which would allow the write to
 	// at least happen atomically.
-	for _, key := range keys {
-		cacheValue := ci.cache[key]
+	// The dirty keys are written in sorted order.
+	for node := ci.dirtyKeys.First(); node != nil; node = node.next[0] {
+		key := node.key
+		cacheValue := ci.cache[string(key)]
 		if cacheValue.deleted {
-			ci.parent.Delete([]byte(key))
+			del(key)
 		} else if cacheValue.value == nil {
 			// Skip, it already doesn't exist in parent.
 		} else {
-			ci.parent.Set([]byte(key), cacheValue.value)
+			set(key, cacheValue.value)
 		}
 	}
 
 	// Clear the cache
 	ci.cache = make(map[string]cValue)
+	ci.dirtyKeys = newSkipList()
+}
+
+// Implements KVStore.
+func (ci *cacheKVStore) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, ci)
 }
 
 //----------------------------------------
@@ -141,31 +143,10 @@ func (ci *cacheKVStore) iterator(start, end []byte, ascending bool) Iterator {
 	} else {
 		parent = ci.parent.ReverseIterator(start, end)
 	}
-	items := ci.dirtyItems(ascending)
-	cache = newMemIterator(start, end, items)
+	cache = newMemIterator(start, end, ci.dirtyKeys, ci.cache, ascending)
 	return newCacheMergeIterator(parent, cache, ascending)
 }
 
-// Constructs a slice of dirty items, to use w/ memIterator.
-func (ci *cacheKVStore) dirtyItems(ascending bool) []cmn.KVPair {
-	items := make([]cmn.KVPair, 0, len(ci.cache))
-	for key, cacheValue := range ci.cache {
-		if !cacheValue.dirty {
-			continue
-		}
-		items = append(items,
-			cmn.KVPair{[]byte(key), cacheValue.value})
-	}
-	sort.Slice(items, func(i, j int) bool {
-		if ascending {
-			return bytes.Compare(items[i].Key, items[j].Key) < 0
-		} else {
-			return bytes.Compare(items[i].Key, items[j].Key) > 0
-		}
-	})
-	return items
-}
-
 //----------------------------------------
 // etc
 
@@ -183,4 +164,7 @@ func (ci *cacheKVStore) setCacheValue(key, value []byte, deleted bool, dirty boo
 		dirty:   dirty,
 	}
 	ci.cache[string(key)] = cacheValue
+	if dirty {
+		ci.dirtyKeys.Insert(key)
+	}
 }
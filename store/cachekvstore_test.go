@@ -5,6 +5,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tendermint/iavl"
 	cmn "github.com/tendermint/tmlibs/common"
 	dbm "github.com/tendermint/tmlibs/db"
 )
@@ -140,6 +141,42 @@ func TestCacheKVIteratorBounds(t *testing.T) {
 	assert.Equal(t, 4, i)
 }
 
+// Test reverse iteration over dirty keys and deletes,
+// on top of an IAVL store, as a prefixStore does.
+func TestCacheKVReverseIteratorIAVL(t *testing.T) {
+	parent := newIAVLStore(iavl.NewVersionedTree(dbm.NewMemDB(), cacheSize), pruning)
+	truth := dbm.NewMemDB()
+	setRange(parent, truth, 0, 20)
+	parent.Commit()
+
+	st := NewCacheKVStore(parent)
+	setRange(st, truth, 20, 25)
+	st.Set(keyFmt(5), valFmt(50))
+	truth.Set(keyFmt(5), valFmt(50))
+	deleteRange(st, truth, 10, 13)
+	doOp(st, truth, opDel, 0)
+	doOp(st, truth, opDel, 19)
+	doOp(st, truth, opDel, 30) // not in the parent
+
+	domains := [][2][]byte{
+		{nil, nil},
+		{keyFmt(15), nil},
+		{nil, keyFmt(5)},
+		{keyFmt(22), keyFmt(3)},
+		{keyFmt(12), keyFmt(9)},
+		{keyFmt(19), keyFmt(18)},
+	}
+	for _, domain := range domains {
+		checkIterators(t, st.ReverseIterator(domain[0], domain[1]), truth.ReverseIterator(domain[0], domain[1]))
+	}
+
+	// the same once written
+	st.Write()
+	for _, domain := range domains {
+		checkIterators(t, parent.ReverseIterator(domain[0], domain[1]), truth.ReverseIterator(domain[0], domain[1]))
+	}
+}
+
 func TestCacheKVMergeIteratorBasics(t *testing.T) {
 	st := newCacheKVStore()
 
@@ -494,3 +531,24 @@ func (krc *keyRangeCounter) key() int {
 //--------------------------------------------------------
 
 func bz(s string) []byte { return []byte(s) }
+
+//-------------------------------------------------------------------------------------------
+// Benchmarks
+
+// Iterates over a small range of a large cache, as handlers that
+// iterate in loops do.
+func BenchmarkCacheKVStoreIterator(b *testing.B) {
+	st := newCacheKVStore()
+	for i := 0; i < 10000; i++ {
+		st.Set(keyFmt(i), valFmt(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := randInt(10000)
+		itr := st.Iterator(keyFmt(start), keyFmt(start+10))
+		for ; itr.Valid(); itr.Next() {
+			_, _ = itr.Key(), itr.Value()
+		}
+		itr.Close()
+	}
+}
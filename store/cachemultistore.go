@@ -1,6 +1,11 @@
 package store
 
 import (
+	"io"
+
+	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
+
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
@@ -13,26 +18,43 @@ type cacheMultiStore struct {
 	db         CacheKVStore
 	stores     map[StoreKey]CacheWrap
 	keysByName map[string]StoreKey
+
+	traceWriter *traceWriter
 }
 
-var _ CacheMultiStore = cacheMultiStore{}
+var _ CacheMultiStore = (*cacheMultiStore)(nil)
 
-func newCacheMultiStoreFromRMS(rms *rootMultiStore) cacheMultiStore {
-	cms := cacheMultiStore{
-		db:         NewCacheKVStore(dbStoreAdapter{rms.db}),
-		stores:     make(map[StoreKey]CacheWrap, len(rms.stores)),
-		keysByName: rms.keysByName,
-	}
+func newCacheMultiStoreFromRMS(rms *rootMultiStore) *cacheMultiStore {
+	stores := make(map[StoreKey]CacheWrapper, len(rms.stores))
 	for key, store := range rms.stores {
+		stores[key] = store
+	}
+	return newCacheMultiStoreFromStores(rms.db, stores, rms.keysByName, rms.traceWriter)
+}
+
+// The KVStores returned by GetTracedKVStore are traced to traceWriter,
+// if it is set.
+func newCacheMultiStoreFromStores(db dbm.DB, stores map[StoreKey]CacheWrapper, keysByName map[string]StoreKey,
+	traceWriter *traceWriter) *cacheMultiStore {
+
+	cms := &cacheMultiStore{
+		db:          NewCacheKVStore(dbStoreAdapter{db}),
+		stores:      make(map[StoreKey]CacheWrap, len(stores)),
+		keysByName:  keysByName,
+		traceWriter: traceWriter,
+	}
+	for key, store := range stores {
 		cms.stores[key] = store.CacheWrap()
 	}
 	return cms
 }
 
-func newCacheMultiStoreFromCMS(cms cacheMultiStore) cacheMultiStore {
-	cms2 := cacheMultiStore{
-		db:     NewCacheKVStore(cms.db),
-		stores: make(map[StoreKey]CacheWrap, len(cms.stores)),
+func newCacheMultiStoreFromCMS(cms *cacheMultiStore) *cacheMultiStore {
+	cms2 := &cacheMultiStore{
+		db:          NewCacheKVStore(cms.db),
+		stores:      make(map[StoreKey]CacheWrap, len(cms.stores)),
+		keysByName:  cms.keysByName,
+		traceWriter: cms.traceWriter,
 	}
 	for key, store := range cms.stores {
 		cms2.stores[key] = store.CacheWrap()
@@ -41,12 +63,12 @@ func newCacheMultiStoreFromCMS(cms cacheMultiStore) cacheMultiStore {
 }
 
 // Implements Store.
-func (cms cacheMultiStore) GetStoreType() StoreType {
+func (cms *cacheMultiStore) GetStoreType() StoreType {
 	return sdk.StoreTypeMulti
 }
 
 // Implements CacheMultiStore.
-func (cms cacheMultiStore) Write() {
+func (cms *cacheMultiStore) Write() {
 	cms.db.Write()
 	for _, store := range cms.stores {
 		store.Write()
@@ -54,21 +76,43 @@ func (cms cacheMultiStore) Write() {
 }
 
 // Implements CacheWrapper.
-func (cms cacheMultiStore) CacheWrap() CacheWrap {
+func (cms *cacheMultiStore) CacheWrap() CacheWrap {
 	return cms.CacheMultiStore().(CacheWrap)
 }
 
 // Implements MultiStore.
-func (cms cacheMultiStore) CacheMultiStore() CacheMultiStore {
+func (cms *cacheMultiStore) CacheMultiStore() CacheMultiStore {
 	return newCacheMultiStoreFromCMS(cms)
 }
 
 // Implements MultiStore.
-func (cms cacheMultiStore) GetStore(key StoreKey) Store {
+func (cms *cacheMultiStore) GetStore(key StoreKey) Store {
 	return cms.stores[key].(Store)
 }
 
 // Implements MultiStore.
-func (cms cacheMultiStore) GetKVStore(key StoreKey) KVStore {
+func (cms *cacheMultiStore) GetKVStore(key StoreKey) KVStore {
 	return cms.stores[key].(KVStore)
 }
+
+// Implements MultiStore.
+// Only the cache-wraps made afterwards share the tracer.
+func (cms *cacheMultiStore) SetTracer(w io.Writer, logger log.Logger) {
+	cms.traceWriter = newTraceWriter(w, logger)
+}
+
+// Implements MultiStore.
+func (cms *cacheMultiStore) TracingEnabled() bool {
+	return cms.traceWriter != nil
+}
+
+// Implements MultiStore.
+// The operations are traced as they are made, above the cache, so that
+// writing the cache down doesn't trace them again.
+func (cms *cacheMultiStore) GetTracedKVStore(key StoreKey, tc TraceContext) KVStore {
+	store := cms.GetKVStore(key)
+	if cms.TracingEnabled() {
+		store = NewTraceKVStore(store, key.Name(), cms.traceWriter, nil, tc)
+	}
+	return store
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"fmt"
+
+	abci "github.com/tendermint/abci/types"
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+var _ KVStore = (*dbStore)(nil)
+var _ CommitStore = (*dbStore)(nil)
+var _ Queryable = (*dbStore)(nil)
+
+// dbStore is a committed KVStore written to a dbm.DB, under a prefix.
+// It isn't merklized and keeps no history, which suits large indexes
+// that don't need proofs.  Its CommitID only holds the version, so that
+// it can still be part of the commitInfo.
+// The writes are buffered until the rootMultiStore commits them, in the
+// same batch as the commitInfo.
+// Implements KVStore and CommitStore.
+type dbStore struct {
+	cache        *cacheKVStore // the writes since the last commit
+	prefix       []byte
+	lastCommitID CommitID
+}
+
+// NOTE: A dbStore only holds its latest state, so id must be the latest.
+func newDBStore(db dbm.DB, prefix []byte, id CommitID) *dbStore {
+	return &dbStore{
+		cache:        NewCacheKVStore(NewPrefixStore(dbStoreAdapter{db}, prefix)),
+		prefix:       cp(prefix),
+		lastCommitID: CommitID{Version: id.Version},
+	}
+}
+
+// Implements Committer.
+// This only bumps the version, the writes are flushed by writeBatch.
+func (st *dbStore) Commit() CommitID {
+	st.lastCommitID = CommitID{Version: st.lastCommitID.Version + 1}
+	return st.lastCommitID
+}
+
+// Adds the buffered writes to the batch, under the prefix.
+func (st *dbStore) writeBatch(batch dbm.Batch) {
+	st.cache.write(func(key, value []byte) {
+		batch.Set(append(cp(st.prefix), key...), value)
+	}, func(key []byte) {
+		batch.Delete(append(cp(st.prefix), key...))
+	})
+}
+
+// Implements Committer.
+func (st *dbStore) LastCommitID() CommitID {
+	return st.lastCommitID
+}
+
+// Implements Store.
+func (st *dbStore) GetStoreType() StoreType {
+	return sdk.StoreTypeDB
+}
+
+// Implements KVStore.
+func (st *dbStore) Get(key []byte) []byte {
+	return st.cache.Get(key)
+}
+
+// Implements KVStore.
+func (st *dbStore) Has(key []byte) bool {
+	return st.cache.Has(key)
+}
+
+// Implements KVStore.
+func (st *dbStore) Set(key, value []byte) {
+	st.cache.Set(key, value)
+}
+
+// Implements KVStore.
+func (st *dbStore) Delete(key []byte) {
+	st.cache.Delete(key)
+}
+
+// Implements KVStore.
+func (st *dbStore) Iterator(start, end []byte) Iterator {
+	return st.cache.Iterator(start, end)
+}
+
+// Implements KVStore.
+func (st *dbStore) ReverseIterator(start, end []byte) Iterator {
+	return st.cache.ReverseIterator(start, end)
+}
+
+// Implements Store.
+func (st *dbStore) CacheWrap() CacheWrap {
+	return NewCacheKVStore(st)
+}
+
+// Implements KVStore.
+func (st *dbStore) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, st)
+}
+
+// Query only serves the latest version, without proofs.
+// Implements Queryable.
+func (st *dbStore) Query(req abci.RequestQuery) (res abci.ResponseQuery) {
+	if len(req.Data) == 0 {
+		msg := "Query cannot be zero length"
+		return sdk.ErrTxParse(msg).Result().ToQuery()
+	}
+	if req.Prove {
+		msg := "Query proofs are not supported by db stores"
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+	height := st.lastCommitID.Version
+	if req.Height != 0 && req.Height != height {
+		msg := fmt.Sprintf("Query height %v is not the latest height %v", req.Height, height)
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+	res.Height = height
+
+	switch req.Path {
+	case "/store", "/key": // Get by key
+		key := req.Data // Data holds the key bytes
+		res.Key = key
+		res.Value = st.Get(key)
+
+	case "/subspace": // Get all pairs with the prefix
+		subspace := req.Data // Data holds the prefix bytes
+		res.Key = subspace
+		kvs := []cmn.KVPair{}
+		iter := st.Iterator(subspace, sdk.PrefixEndBytes(subspace))
+		for ; iter.Valid(); iter.Next() {
+			kvs = append(kvs, cmn.KVPair{Key: iter.Key(), Value: iter.Value()})
+		}
+		iter.Close()
+		var err error
+		res.Value, err = cdc.MarshalBinary(kvs)
+		if err != nil {
+			panic(err)
+		}
+
+	default:
+		msg := fmt.Sprintf("Unexpected Query path: %v", req.Path)
+		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
+	}
+	return
+}
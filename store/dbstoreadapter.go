@@ -19,5 +19,10 @@ func (dsa dbStoreAdapter) CacheWrap() CacheWrap {
 	return NewCacheKVStore(dsa)
 }
 
+// Implements KVStore.
+func (dsa dbStoreAdapter) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, dsa)
+}
+
 // dbm.DB implements KVStore so we can CacheKVStore it.
 var _ KVStore = dbStoreAdapter{dbm.DB(nil)}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	dbm "github.com/tendermint/tmlibs/db"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Implements CommitMultiStore.
+// The stores are compared by their hashes in the commitInfos first, so
+// only the stores that changed are walked, in order of their names, and
+// each in ascending order of keys.  A nested multistore is diffed in
+// turn, with its stores named "<name>/<store name>".  Db stores keep no
+// history, and are skipped.  A store committed in only one of the
+// versions has all its keys added or deleted.  The diffs are passed to fn
+// as they are found, so they are never all held in memory.
+func (rs *rootMultiStore) DiffVersions(from, to int64, fn func(storeName string, diff KVDiff) (stop bool)) error {
+	fromInfos, err := rs.storeInfosAt(from)
+	if err != nil {
+		return err
+	}
+	toInfos, err := rs.storeInfosAt(to)
+	if err != nil {
+		return err
+	}
+
+	// The union of the stores, by name.
+	keys := make(map[string]StoreKey)
+	for key := range fromInfos {
+		keys[key.Name()] = key
+	}
+	for key := range toInfos {
+		keys[key.Name()] = key
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key := keys[name]
+		fromInfo, inFrom := fromInfos[key]
+		toInfo, inTo := toInfos[key]
+		fromID, toID := fromInfo.Core.CommitID, toInfo.Core.CommitID
+		if inFrom && inTo && bytes.Equal(fromID.Hash, toID.Hash) {
+			continue // unchanged
+		}
+
+		var stopped bool
+		switch params := rs.storesParams[key]; params.typ {
+		case sdk.StoreTypeMulti:
+			err = params.multi.DiffVersions(fromID.Version, toID.Version, func(storeName string, diff KVDiff) bool {
+				stopped = fn(name+"/"+storeName, diff)
+				return stopped
+			})
+		case sdk.StoreTypeIAVL:
+			var fromStore, toStore KVStore
+			fromStore, err = rs.iavlStoreAt(key, fromInfo, inFrom)
+			if err == nil {
+				toStore, err = rs.iavlStoreAt(key, toInfo, inTo)
+			}
+			if err == nil {
+				stopped = diffKVStores(fromStore, toStore, func(diff KVDiff) bool {
+					return fn(name, diff)
+				})
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to diff store %v: %v", name, err)
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Returns the infos of the stores committed in version ver, by key.
+// Version 0 has none.
+func (rs *rootMultiStore) storeInfosAt(ver int64) (map[StoreKey]storeInfo, error) {
+	if ver < 0 || ver > rs.lastCommitID.Version {
+		return nil, fmt.Errorf("Version %v is not a committed version", ver)
+	}
+	infos := make(map[StoreKey]storeInfo)
+	if ver == 0 {
+		return infos, nil
+	}
+
+	cInfo, err := getCommitInfo(rs.db, ver)
+	if err != nil {
+		return nil, err
+	}
+	for _, storeInfo := range cInfo.StoreInfos {
+		key, err := rs.nameToKey(storeInfo.Name, ver)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load version %v: %v", ver, err)
+		}
+		if key != nil {
+			infos[key] = storeInfo
+		}
+	}
+	return infos, nil
+}
+
+// Loads an IAVL store at its committed version, or returns nil if it
+// wasn't committed.
+func (rs *rootMultiStore) iavlStoreAt(key StoreKey, storeInfo storeInfo, committed bool) (KVStore, error) {
+	if !committed {
+		return nil, nil
+	}
+	store, err := rs.loadCommitStoreFromParams(key, storeInfo.Core.CommitID, rs.storesParams[key])
+	if err != nil {
+		return nil, err
+	}
+	return store.(KVStore), nil
+}
+
+// Walks both stores in ascending order of keys, and calls fn with each
+// key that differs, until it returns true.  A nil store is empty.
+// Returns whether fn stopped the walk.
+func diffKVStores(from, to KVStore, fn func(diff KVDiff) (stop bool)) bool {
+	fromIter, toIter := diffIterator(from), diffIterator(to)
+	defer fromIter.Close()
+	defer toIter.Close()
+
+	for fromIter.Valid() || toIter.Valid() {
+		var cmp int
+		switch {
+		case !fromIter.Valid():
+			cmp = 1
+		case !toIter.Valid():
+			cmp = -1
+		default:
+			cmp = bytes.Compare(fromIter.Key(), toIter.Key())
+		}
+
+		var diff *KVDiff
+		switch {
+		case cmp < 0:
+			diff = &KVDiff{Key: fromIter.Key(), Old: fromIter.Value()}
+			fromIter.Next()
+		case cmp > 0:
+			diff = &KVDiff{Key: toIter.Key(), New: toIter.Value()}
+			toIter.Next()
+		default:
+			if !bytes.Equal(fromIter.Value(), toIter.Value()) {
+				diff = &KVDiff{Key: fromIter.Key(), Old: fromIter.Value(), New: toIter.Value()}
+			}
+			fromIter.Next()
+			toIter.Next()
+		}
+		if diff != nil && fn(*diff) {
+			return true
+		}
+	}
+	return false
+}
+
+func diffIterator(store KVStore) Iterator {
+	if store == nil {
+		store = dbStoreAdapter{dbm.NewMemDB()}
+	}
+	return store.Iterator(nil, nil)
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+type storeKVDiff struct {
+	Store string
+	KVDiff
+}
+
+// Collects the diffs, up to limit if it's positive.
+func diffVersions(store CommitMultiStore, from, to int64, limit int) ([]storeKVDiff, error) {
+	diffs := []storeKVDiff{}
+	err := store.DiffVersions(from, to, func(storeName string, diff KVDiff) bool {
+		diffs = append(diffs, storeKVDiff{storeName, diff})
+		return len(diffs) == limit
+	})
+	return diffs, err
+}
+
+func TestDiffVersions(t *testing.T) {
+	key1, key2 := sdk.NewKVStoreKey("store1"), sdk.NewKVStoreKey("store2")
+	keyIndex := sdk.NewKVStoreKey("index")
+	store := NewCommitMultiStore(dbm.NewMemDB())
+	store.MountStoreWithDB(key1, sdk.StoreTypeIAVL, dbm.NewMemDB())
+	store.MountStoreWithDB(key2, sdk.StoreTypeIAVL, dbm.NewMemDB())
+	store.MountStoreWithDB(keyIndex, sdk.StoreTypeDB, nil)
+	err := store.LoadLatestVersion()
+	require.Nil(t, err)
+
+	// version 1
+	kv1 := store.GetKVStore(key1)
+	kv1.Set(bz("a"), bz("1"))
+	kv1.Set(bz("b"), bz("1"))
+	kv1.Set(bz("c"), bz("1"))
+	store.GetKVStore(key2).Set(bz("a"), bz("1"))
+	store.GetKVStore(keyIndex).Set(bz("a"), bz("1"))
+	store.Commit()
+
+	// version 2 only changes store1, and the index
+	kv1.Set(bz("b"), bz("2"))
+	kv1.Delete(bz("c"))
+	kv1.Set(bz("d"), bz("2"))
+	kv1.Set(bz("a"), bz("1"))
+	store.GetKVStore(keyIndex).Set(bz("a"), bz("2"))
+	store.Commit()
+
+	diffs, err := diffVersions(store, 1, 2, 0)
+	require.Nil(t, err)
+	assert.Equal(t, []storeKVDiff{
+		{"store1", KVDiff{Key: bz("b"), Old: bz("1"), New: bz("2")}},
+		{"store1", KVDiff{Key: bz("c"), Old: bz("1")}},
+		{"store1", KVDiff{Key: bz("d"), New: bz("2")}},
+	}, diffs)
+
+	// the other way around
+	diffs, err = diffVersions(store, 2, 1, 0)
+	require.Nil(t, err)
+	assert.Equal(t, []storeKVDiff{
+		{"store1", KVDiff{Key: bz("b"), Old: bz("2"), New: bz("1")}},
+		{"store1", KVDiff{Key: bz("c"), New: bz("1")}},
+		{"store1", KVDiff{Key: bz("d"), Old: bz("2")}},
+	}, diffs)
+
+	// from the empty state, sorted by store name
+	diffs, err = diffVersions(store, 0, 1, 0)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(diffs))
+	assert.Equal(t, "store1", diffs[0].Store)
+	assert.Equal(t, storeKVDiff{"store2", KVDiff{Key: bz("a"), New: bz("1")}}, diffs[3])
+
+	// until stopped
+	diffs, err = diffVersions(store, 0, 1, 2)
+	require.Nil(t, err)
+	assert.Equal(t, 2, len(diffs))
+
+	// no diff
+	diffs, err = diffVersions(store, 2, 2, 0)
+	require.Nil(t, err)
+	assert.Equal(t, 0, len(diffs))
+
+	// only committed versions
+	_, err = diffVersions(store, 1, 3, 0)
+	assert.NotNil(t, err)
+	_, err = diffVersions(store, -1, 2, 0)
+	assert.NotNil(t, err)
+}
+
+func TestDiffVersionsNested(t *testing.T) {
+	store := NewCommitMultiStore(dbm.NewMemDB())
+	mkey, key := sdk.NewKVStoreKey("multi"), sdk.NewKVStoreKey("store1")
+	store.MountStoreWithDB(mkey, sdk.StoreTypeMulti, dbm.NewMemDB())
+	sub := store.GetCommitStore(mkey).(CommitMultiStore)
+	sub.MountStoreWithDB(key, sdk.StoreTypeIAVL, dbm.NewMemDB())
+	err := store.LoadLatestVersion()
+	require.Nil(t, err)
+
+	sub.GetKVStore(key).Set(bz("a"), bz("1"))
+	store.Commit()
+	sub.GetKVStore(key).Set(bz("a"), bz("2"))
+	store.Commit()
+
+	diffs, err := diffVersions(store, 1, 2, 0)
+	require.Nil(t, err)
+	assert.Equal(t, []storeKVDiff{
+		{"multi/store1", KVDiff{Key: bz("a"), Old: bz("1"), New: bz("2")}},
+	}, diffs)
+}
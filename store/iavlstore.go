@@ -2,7 +2,8 @@ package store
 
 import (
 	"fmt"
-	"sync"
+	"strconv"
+	"strings"
 
 	abci "github.com/tendermint/abci/types"
 	"github.com/tendermint/iavl"
@@ -13,20 +14,95 @@ import (
 )
 
 const (
-	defaultIAVLCacheSize  = 10000
-	defaultIAVLNumHistory = 1<<53 - 1 // DEPRECATED
+	defaultIAVLCacheSize = 10000
+
+	// The layout of an IAVL tree in its db, which rollbacks and snapshots
+	// work on directly.
+	iavlNodeKeyFmt   = "n/%X" // n/<hash>
+	iavlOrphanPrefix = "o/"   // o/<to version>/<from version>/<hash>
+	iavlRootPrefix   = "r/"   // r/<version>
 )
 
-func LoadIAVLStore(db dbm.DB, id CommitID) (CommitStore, error) {
+func LoadIAVLStore(db dbm.DB, id CommitID, pruning PruningOptions) (CommitStore, error) {
 	tree := iavl.NewVersionedTree(db, defaultIAVLCacheSize)
 	err := tree.LoadVersion(id.Version)
 	if err != nil {
 		return nil, err
 	}
-	store := newIAVLStore(tree, defaultIAVLNumHistory)
+	store := newIAVLStore(tree, pruning)
 	return store, nil
 }
 
+// Deletes the versions of the tree in db after ver, the way iavl's
+// DeleteVersionsFrom does: the roots of the later versions go, and so do
+// the nodes they orphaned.  The orphan records up to ver go too, as the
+// nodes they hold are in the tree of ver again, and pruning ver later
+// must not delete them from the trees built on it.  Nodes that the later
+// versions created and never orphaned are unreachable, and are left.
+func rollbackIAVLStore(db dbm.DB, ver int64) error {
+	tree := iavl.NewVersionedTree(db, defaultIAVLCacheSize)
+	err := tree.LoadVersion(ver)
+	if err != nil {
+		return err
+	}
+
+	batch := db.NewBatch()
+	orphans := NewPrefixStore(dbStoreAdapter{db}, []byte(iavlOrphanPrefix))
+	iter := orphans.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		toVersion, fromVersion, err := parseIAVLOrphanKey(iter.Key())
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		switch {
+		case fromVersion > ver:
+			batch.Delete(iavlNodeKey(iter.Value()))
+			batch.Delete(append([]byte(iavlOrphanPrefix), iter.Key()...))
+		case toVersion >= ver:
+			batch.Delete(append([]byte(iavlOrphanPrefix), iter.Key()...))
+		}
+	}
+	iter.Close()
+
+	roots := NewPrefixStore(dbStoreAdapter{db}, []byte(iavlRootPrefix))
+	iter = roots.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		version, err := strconv.ParseInt(string(iter.Key()), 10, 64)
+		if err != nil {
+			iter.Close()
+			return fmt.Errorf("Invalid IAVL root key %q", iter.Key())
+		}
+		if version > ver {
+			batch.Delete(append([]byte(iavlRootPrefix), iter.Key()...))
+		}
+	}
+	iter.Close()
+
+	batch.Write()
+	return nil
+}
+
+// Returns the key of an IAVL node in its db.
+func iavlNodeKey(hash []byte) []byte {
+	return []byte(fmt.Sprintf(iavlNodeKeyFmt, hash))
+}
+
+// Parses the key of an IAVL orphan record, without its prefix.
+func parseIAVLOrphanKey(key []byte) (toVersion, fromVersion int64, err error) {
+	parts := strings.SplitN(string(key), "/", 3)
+	if len(parts) == 3 {
+		toVersion, err = strconv.ParseInt(parts[0], 10, 64)
+		if err == nil {
+			fromVersion, err = strconv.ParseInt(parts[1], 10, 64)
+		}
+	}
+	if len(parts) != 3 || err != nil {
+		return 0, 0, fmt.Errorf("Invalid IAVL orphan key %q", key)
+	}
+	return toVersion, fromVersion, nil
+}
+
 //----------------------------------------
 
 var _ KVStore = (*iavlStore)(nil)
@@ -39,16 +115,15 @@ type iavlStore struct {
 	// The underlying tree.
 	tree *iavl.VersionedTree
 
-	// How many old versions we hold onto.
-	// A value of 0 means keep all history.
-	numHistory int64
+	// Which old versions we hold onto.
+	pruning PruningOptions
 }
 
 // CONTRACT: tree should be fully loaded.
-func newIAVLStore(tree *iavl.VersionedTree, numHistory int64) *iavlStore {
+func newIAVLStore(tree *iavl.VersionedTree, pruning PruningOptions) *iavlStore {
 	st := &iavlStore{
-		tree:       tree,
-		numHistory: numHistory,
+		tree:    tree,
+		pruning: pruning,
 	}
 	return st
 }
@@ -63,10 +138,14 @@ func (st *iavlStore) Commit() CommitID {
 		panic(err)
 	}
 
-	// Release an old version of history
-	if st.numHistory > 0 && (st.numHistory < st.tree.Version64()) {
-		toRelease := version - st.numHistory
-		st.tree.DeleteVersion(toRelease)
+	// Release the version that just left the recent history,
+	// unless it is kept as a snapshot.
+	toRelease := version - st.pruning.KeepRecent
+	if st.pruning.Prunes(toRelease, version) && st.tree.VersionExists(toRelease) {
+		err = st.tree.DeleteVersion(toRelease)
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	return CommitID{
@@ -93,6 +172,11 @@ func (st *iavlStore) CacheWrap() CacheWrap {
 	return NewCacheKVStore(st)
 }
 
+// Implements KVStore.
+func (st *iavlStore) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, st)
+}
+
 // Implements KVStore.
 func (st *iavlStore) Set(key, value []byte) {
 	st.tree.Set(key, value)
@@ -131,6 +215,12 @@ func (st *iavlStore) ReverseIterator(start, end []byte) Iterator {
 // If latest-1 is not present, use latest (which must be present)
 // if you care to have the latest data to see a tx results, you must
 // explicitly set the height you want to see
+//
+// "/key" returns the value of the key in Data. If the key is missing,
+// the Value is nil and the proof is a KeyAbsentProof.
+// "/subspace" returns the go-wire encoded []cmn.KVPair of all the keys
+// with the prefix in Data. The proof is a KeyRangeProof of the whole
+// subspace, so it also proves that no other keys have the prefix.
 func (st *iavlStore) Query(req abci.RequestQuery) (res abci.ResponseQuery) {
 	if len(req.Data) == 0 {
 		msg := "Query cannot be zero length"
@@ -166,6 +256,30 @@ func (st *iavlStore) Query(req abci.RequestQuery) (res abci.ResponseQuery) {
 			_, res.Value = tree.GetVersioned(key, height)
 		}
 
+	case "/subspace": // Get all pairs with the prefix
+		subspace := req.Data // Data holds the prefix bytes
+		res.Key = subspace
+		end := sdk.PrefixEndBytes(subspace)
+		keys, values, proof, err := tree.GetVersionedRangeWithProof(subspace, end, 0, height)
+		if err != nil {
+			res.Log = err.Error()
+			break
+		}
+		kvs := make([]cmn.KVPair, len(keys))
+		for i := range keys {
+			kvs[i] = cmn.KVPair{Key: keys[i], Value: values[i]}
+		}
+		res.Value, err = cdc.MarshalBinary(kvs)
+		if err != nil {
+			panic(err)
+		}
+		if req.Prove {
+			res.Proof, err = cdc.MarshalBinary(proof)
+			if err != nil {
+				panic(err)
+			}
+		}
+
 	default:
 		msg := fmt.Sprintf("Unexpected Query path: %v", req.Path)
 		return sdk.ErrUnknownRequest(msg).Result().ToQuery()
@@ -175,6 +289,17 @@ func (st *iavlStore) Query(req abci.RequestQuery) (res abci.ResponseQuery) {
 
 //----------------------------------------
 
+// The iavlIterator prefetches pairs from the tree in batches,
+// which grow up to iavlIteratorMaxBatch as the iteration goes on,
+// so that reading only the first few pairs stays cheap.
+const (
+	iavlIteratorMinBatch = 16
+	iavlIteratorMaxBatch = 1024
+)
+
+// iavlIterator is a synchronous iterator over the tree.
+// Each batch is a new IterateRange, starting right after the last key
+// of the previous batch, so there is nothing to leak if Close isn't called.
 // Implements Iterator.
 type iavlIterator struct {
 	// Underlying store
@@ -186,66 +311,46 @@ type iavlIterator struct {
 	// Iteration order
 	ascending bool
 
-	// Channel to push iteration values.
-	iterCh chan cmn.KVPair
-
-	// Close this to release goroutine.
-	quitCh chan struct{}
-
-	// Close this to signal that state is initialized.
-	initCh chan struct{}
-
 	//----------------------------------------
 	// What follows are mutable state.
-	mtx sync.Mutex
 
-	invalid bool   // True once, true forever
-	key     []byte // The current key
-	value   []byte // The current value
+	batch     []cmn.KVPair // The prefetched pairs
+	pos       int          // The current pair in the batch
+	batchSize int          // The size of the next batch
+	done      bool         // True once the tree has no pairs beyond the batch
+
+	// The range of the next batch, narrowed after each batch.
+	nextStart, nextEnd []byte
 }
 
 var _ Iterator = (*iavlIterator)(nil)
 
-// newIAVLIterator will create a new iavlIterator.
-// CONTRACT: Caller must release the iavlIterator, as each one creates a new
-// goroutine.
+// newIAVLIterator will create a new iavlIterator, positioned at the first pair.
 func newIAVLIterator(tree *iavl.Tree, start, end []byte, ascending bool) *iavlIterator {
 	iter := &iavlIterator{
 		tree:      tree,
 		start:     cp(start),
 		end:       cp(end),
 		ascending: ascending,
-		iterCh:    make(chan cmn.KVPair, 0), // Set capacity > 0?
-		quitCh:    make(chan struct{}),
-		initCh:    make(chan struct{}),
+		batchSize: iavlIteratorMinBatch,
+	}
+	// The tree ranges include their start and exclude their end.  A
+	// descending domain goes from start, included, down to end, excluded,
+	// i.e. it is the range [end+0x00, start+0x00).
+	if ascending {
+		iter.nextStart, iter.nextEnd = iter.start, iter.end
+	} else {
+		if end != nil {
+			iter.nextStart = append(cp(end), 0x00)
+		}
+		if start != nil {
+			iter.nextEnd = append(cp(start), 0x00)
+		}
 	}
-	go iter.iterateRoutine()
-	go iter.initRoutine()
+	iter.fetch()
 	return iter
 }
 
-// Run this to funnel items from the tree to iterCh.
-func (iter *iavlIterator) iterateRoutine() {
-	iter.tree.IterateRange(
-		iter.start, iter.end, iter.ascending,
-		func(key, value []byte) bool {
-			select {
-			case <-iter.quitCh:
-				return true // done with iteration.
-			case iter.iterCh <- cmn.KVPair{key, value}:
-				return false // yay.
-			}
-		},
-	)
-	close(iter.iterCh) // done.
-}
-
-// Run this to fetch the first item.
-func (iter *iavlIterator) initRoutine() {
-	iter.receiveNext()
-	close(iter.initCh)
-}
-
 // Implements Iterator.
 func (iter *iavlIterator) Domain() (start, end []byte) {
 	return iter.start, iter.end
@@ -253,78 +358,74 @@ func (iter *iavlIterator) Domain() (start, end []byte) {
 
 // Implements Iterator.
 func (iter *iavlIterator) Valid() bool {
-	iter.waitInit()
-	iter.mtx.Lock()
-	defer iter.mtx.Unlock()
-
-	return !iter.invalid
+	return iter.pos < len(iter.batch)
 }
 
 // Implements Iterator.
 func (iter *iavlIterator) Next() {
-	iter.waitInit()
-	iter.mtx.Lock()
-	defer iter.mtx.Unlock()
 	iter.assertIsValid()
 
-	iter.receiveNext()
+	iter.pos++
+	if iter.pos == len(iter.batch) && !iter.done {
+		iter.fetch()
+	}
 }
 
 // Implements Iterator.
 func (iter *iavlIterator) Key() []byte {
-	iter.waitInit()
-	iter.mtx.Lock()
-	defer iter.mtx.Unlock()
 	iter.assertIsValid()
 
-	return iter.key
+	return iter.batch[iter.pos].Key
 }
 
 // Implements Iterator.
 func (iter *iavlIterator) Value() []byte {
-	iter.waitInit()
-	iter.mtx.Lock()
-	defer iter.mtx.Unlock()
 	iter.assertIsValid()
 
-	return iter.value
+	return iter.batch[iter.pos].Value
 }
 
 // Implements Iterator.
+// Releases the batch; the iterator is invalid afterwards.
 func (iter *iavlIterator) Close() {
-	close(iter.quitCh)
+	iter.batch, iter.pos = nil, 0
+	iter.done = true
 }
 
 //----------------------------------------
 
-func (iter *iavlIterator) setNext(key, value []byte) {
-	iter.assertIsValid()
-
-	iter.key = key
-	iter.value = value
-}
-
-func (iter *iavlIterator) setInvalid() {
-	iter.assertIsValid()
-
-	iter.invalid = true
-}
-
-func (iter *iavlIterator) waitInit() {
-	<-iter.initCh
-}
+// Fetches the next batch of pairs from the tree,
+// and narrows the range past the last one.
+func (iter *iavlIterator) fetch() {
+	batch := make([]cmn.KVPair, 0, iter.batchSize)
+	iter.tree.IterateRange(
+		iter.nextStart, iter.nextEnd, iter.ascending,
+		func(key, value []byte) bool {
+			batch = append(batch, cmn.KVPair{Key: key, Value: value})
+			return len(batch) == cap(batch) // stop once full.
+		},
+	)
+	iter.batch, iter.pos = batch, 0
 
-func (iter *iavlIterator) receiveNext() {
-	kvPair, ok := <-iter.iterCh
-	if ok {
-		iter.setNext(kvPair.Key, kvPair.Value)
+	if len(batch) < iter.batchSize {
+		iter.done = true
+		return
+	}
+	// The start is inclusive and the end exclusive, so the next batch
+	// starts at the key right after the last one, or ends at the last one.
+	last := batch[len(batch)-1].Key
+	if iter.ascending {
+		iter.nextStart = append(cp(last), 0x00)
 	} else {
-		iter.setInvalid()
+		iter.nextEnd = cp(last)
+	}
+	if iter.batchSize < iavlIteratorMaxBatch {
+		iter.batchSize *= 2
 	}
 }
 
 func (iter *iavlIterator) assertIsValid() {
-	if iter.invalid {
+	if !iter.Valid() {
 		panic("invalid iterator")
 	}
 }
@@ -332,6 +433,9 @@ func (iter *iavlIterator) assertIsValid() {
 //----------------------------------------
 
 func cp(bz []byte) (ret []byte) {
+	if bz == nil {
+		return nil // unbounded, for the iterators
+	}
 	ret = make([]byte, len(bz))
 	copy(ret, bz)
 	return ret
@@ -1,6 +1,8 @@
 package store
 
 import (
+	"fmt"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -14,8 +16,8 @@ import (
 )
 
 var (
-	cacheSize        = 100
-	numHistory int64 = 5
+	cacheSize = 100
+	pruning   = sdk.PruningOptions{KeepRecent: 5}
 )
 
 var (
@@ -45,7 +47,7 @@ func newTree(t *testing.T, db dbm.DB) (*iavl.VersionedTree, CommitID) {
 func TestIAVLStoreGetSetHasDelete(t *testing.T) {
 	db := dbm.NewMemDB()
 	tree, _ := newTree(t, db)
-	iavlStore := newIAVLStore(tree, numHistory)
+	iavlStore := newIAVLStore(tree, pruning)
 
 	key := "hello"
 
@@ -70,7 +72,7 @@ func TestIAVLStoreGetSetHasDelete(t *testing.T) {
 func TestIAVLIterator(t *testing.T) {
 	db := dbm.NewMemDB()
 	tree, _ := newTree(t, db)
-	iavlStore := newIAVLStore(tree, numHistory)
+	iavlStore := newIAVLStore(tree, pruning)
 	iter := iavlStore.Iterator([]byte("aloha"), []byte("hellz"))
 	expected := []string{"aloha", "hello"}
 	for i := 0; iter.Valid(); iter.Next() {
@@ -82,10 +84,89 @@ func TestIAVLIterator(t *testing.T) {
 	}
 }
 
+func TestIAVLIteratorBatches(t *testing.T) {
+	db := dbm.NewMemDB()
+	tree := iavl.NewVersionedTree(db, cacheSize)
+	iavlStore := newIAVLStore(tree, pruning)
+
+	// enough keys for several batches
+	n := 3*iavlIteratorMaxBatch + 7
+	for i := 0; i < n; i++ {
+		iavlStore.Set([]byte(fmt.Sprintf("key%06d", i)), []byte{byte(i)})
+	}
+
+	i := 0
+	iter := iavlStore.Iterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key%06d", i), string(iter.Key()))
+		assert.Equal(t, []byte{byte(i)}, iter.Value())
+		i++
+	}
+	iter.Close()
+	assert.Equal(t, n, i)
+
+	i = n
+	iter = iavlStore.ReverseIterator(nil, nil)
+	for ; iter.Valid(); iter.Next() {
+		i--
+		assert.Equal(t, fmt.Sprintf("key%06d", i), string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, 0, i)
+
+	// bounds are kept across batches
+	start, end := []byte("key000010"), []byte("key002000")
+	i = 10
+	iter = iavlStore.Iterator(start, end)
+	for ; iter.Valid(); iter.Next() {
+		assert.Equal(t, fmt.Sprintf("key%06d", i), string(iter.Key()))
+		i++
+	}
+	iter.Close()
+	assert.Equal(t, 2000, i)
+}
+
+func TestIAVLIteratorNoLeak(t *testing.T) {
+	db := dbm.NewMemDB()
+	tree, _ := newTree(t, db)
+	iavlStore := newIAVLStore(tree, pruning)
+
+	// iterators that are never closed hold no goroutines
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		iter := iavlStore.Iterator(nil, nil)
+		assert.True(t, iter.Valid())
+	}
+	assert.Equal(t, before, runtime.NumGoroutine())
+
+	// closed iterators are invalid
+	iter := iavlStore.Iterator(nil, nil)
+	iter.Close()
+	assert.False(t, iter.Valid())
+	assert.Panics(t, func() { iter.Key() })
+}
+
+func TestIAVLStorePruning(t *testing.T) {
+	db := dbm.NewMemDB()
+	tree := iavl.NewVersionedTree(db, cacheSize)
+	iavlStore := newIAVLStore(tree, sdk.PruningOptions{KeepRecent: 2, KeepEvery: 3})
+
+	for i := 0; i < 10; i++ {
+		iavlStore.Set([]byte("key"), []byte{byte(i)})
+		iavlStore.Commit()
+	}
+
+	// the 2 recent versions, and every 3rd version
+	for ver := int64(1); ver <= 10; ver++ {
+		kept := ver >= 9 || ver%3 == 0
+		assert.Equal(t, kept, tree.VersionExists(ver), "version %d", ver)
+	}
+}
+
 func TestIAVLStoreQuery(t *testing.T) {
 	db := dbm.NewMemDB()
 	tree := iavl.NewVersionedTree(db, cacheSize)
-	iavlStore := newIAVLStore(tree, numHistory)
+	iavlStore := newIAVLStore(tree, pruning)
 
 	k, v := []byte("wind"), []byte("blows")
 	k2, v2 := []byte("water"), []byte("flows")
@@ -140,4 +221,90 @@ func TestIAVLStoreQuery(t *testing.T) {
 	qres = iavlStore.Query(query0)
 	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
 	assert.Equal(t, v, qres.Value)
+
+	// a missing key comes with an absence proof
+	query3 := abci.RequestQuery{Path: "/key", Data: []byte("fire"), Height: cid.Version, Prove: true}
+	qres = iavlStore.Query(query3)
+	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
+	assert.Nil(t, qres.Value)
+	keyProof, err := iavl.ReadKeyProof(qres.Proof)
+	assert.Nil(t, err)
+	assert.Nil(t, keyProof.Verify([]byte("fire"), nil, cid.Hash))
+
+	// subspace returns all the pairs with the prefix, in order
+	querySub := abci.RequestQuery{Path: "/subspace", Data: []byte("w"), Height: cid.Version, Prove: true}
+	qres = iavlStore.Query(querySub)
+	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
+	var kvs []cmn.KVPair
+	err = cdc.UnmarshalBinary(qres.Value, &kvs)
+	assert.Nil(t, err)
+	expected := []cmn.KVPair{{k2, v2}, {k, v3}}
+	assert.Equal(t, expected, kvs)
+	var rangeProof iavl.KeyRangeProof
+	err = cdc.UnmarshalBinary(qres.Proof, &rangeProof)
+	assert.Nil(t, err)
+	keys, values := [][]byte{k2, k}, [][]byte{v2, v3}
+	end := sdk.PrefixEndBytes([]byte("w"))
+	assert.Nil(t, rangeProof.Verify([]byte("w"), end, 0, keys, values, cid.Hash))
+
+	// the proof doesn't hold if a pair is left out
+	assert.NotNil(t, rangeProof.Verify([]byte("w"), end, 0, keys[:1], values[:1], cid.Hash))
+
+	// an empty subspace
+	querySub.Data = []byte("x")
+	qres = iavlStore.Query(querySub)
+	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
+	var emptyKVs []cmn.KVPair
+	err = cdc.UnmarshalBinary(qres.Value, &emptyKVs)
+	assert.Nil(t, err)
+	assert.Empty(t, emptyKVs)
+}
+
+//----------------------------------------
+// Benchmarks
+
+func newBenchIAVLStore(b *testing.B, n int) *iavlStore {
+	tree := iavl.NewVersionedTree(dbm.NewMemDB(), cacheSize)
+	iavlStore := newIAVLStore(tree, pruning)
+	for i := 0; i < n; i++ {
+		iavlStore.Set(cmn.RandBytes(12), cmn.RandBytes(50))
+	}
+	iavlStore.Commit()
+	return iavlStore
+}
+
+func benchmarkIAVLIterator(b *testing.B, n int, ascending bool) {
+	iavlStore := newBenchIAVLStore(b, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var iter Iterator
+		if ascending {
+			iter = iavlStore.Iterator(nil, nil)
+		} else {
+			iter = iavlStore.ReverseIterator(nil, nil)
+		}
+		for ; iter.Valid(); iter.Next() {
+			_, _ = iter.Key(), iter.Value()
+		}
+		iter.Close()
+	}
+}
+
+func BenchmarkIAVLIterator1K(b *testing.B)         { benchmarkIAVLIterator(b, 1000, true) }
+func BenchmarkIAVLIterator10K(b *testing.B)        { benchmarkIAVLIterator(b, 10000, true) }
+func BenchmarkIAVLReverseIterator1K(b *testing.B)  { benchmarkIAVLIterator(b, 1000, false) }
+func BenchmarkIAVLReverseIterator10K(b *testing.B) { benchmarkIAVLIterator(b, 10000, false) }
+
+// Reads only the first pair, e.g. as a cacheKVStore merge iterator
+// over a small range would.
+func BenchmarkIAVLIteratorFirst(b *testing.B) {
+	iavlStore := newBenchIAVLStore(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		iter := iavlStore.Iterator(nil, nil)
+		if iter.Valid() {
+			_, _ = iter.Key(), iter.Value()
+		}
+		iter.Close()
+	}
 }
@@ -2,32 +2,61 @@ package store
 
 import (
 	"bytes"
-
-	cmn "github.com/tendermint/tmlibs/common"
-	dbm "github.com/tendermint/tmlibs/db"
 )
 
-// Iterates over iterKVCache items.
-// if key is nil, means it was deleted.
+// Iterates over the dirty keys of a cacheKVStore, in a skipList,
+// looking up their values in the cache as it goes.
+// if value is nil, means it was deleted.
 // Implements Iterator.
 type memIterator struct {
 	start, end []byte
-	items      []cmn.KVPair
+	ascending  bool
+
+	// The keys within [lo, hi) are in the domain, nil is unbounded.
+	lo, hi []byte
+
+	cache map[string]cValue
+	node  *skipNode
 }
 
-func newMemIterator(start, end []byte, items []cmn.KVPair) *memIterator {
-	itemsInDomain := make([]cmn.KVPair, 0)
-	for _, item := range items {
-		ascending := keyCompare(start, end) < 0
-		if dbm.IsKeyInDomain(item.Key, start, end, !ascending) {
-			itemsInDomain = append(itemsInDomain, item)
+func newMemIterator(start, end []byte, keys *skipList, cache map[string]cValue, ascending bool) *memIterator {
+	mi := &memIterator{
+		start:     start,
+		end:       end,
+		ascending: ascending,
+		cache:     cache,
+	}
+
+	// An ascending domain includes start and excludes end.  A descending
+	// one goes from start, included, down to end, excluded.  Both become
+	// [lo, hi) as key > k is key >= k+0x00.
+	if ascending {
+		mi.lo, mi.hi = start, end
+	} else {
+		if end != nil {
+			mi.lo = append(cp(end), 0x00)
+		}
+		if start != nil {
+			mi.hi = append(cp(start), 0x00)
 		}
 	}
-	return &memIterator{
-		start: start,
-		end:   end,
-		items: itemsInDomain,
+
+	if ascending {
+		if mi.lo == nil {
+			mi.node = keys.First()
+		} else {
+			mi.node = keys.Seek(mi.lo)
+		}
+	} else {
+		if mi.hi == nil {
+			mi.node = keys.Last()
+		} else if node := keys.Seek(mi.hi); node != nil {
+			mi.node = node.prev
+		} else {
+			mi.node = keys.Last()
+		}
 	}
+	return mi
 }
 
 func (mi *memIterator) Domain() ([]byte, []byte) {
@@ -35,7 +64,13 @@ func (mi *memIterator) Domain() ([]byte, []byte) {
 }
 
 func (mi *memIterator) Valid() bool {
-	return len(mi.items) > 0
+	if mi.node == nil {
+		return false
+	}
+	if mi.ascending {
+		return mi.hi == nil || bytes.Compare(mi.node.key, mi.hi) < 0
+	}
+	return mi.lo == nil || bytes.Compare(mi.node.key, mi.lo) >= 0
 }
 
 func (mi *memIterator) assertValid() {
@@ -46,35 +81,26 @@ func (mi *memIterator) assertValid() {
 
 func (mi *memIterator) Next() {
 	mi.assertValid()
-	mi.items = mi.items[1:]
+	if mi.ascending {
+		mi.node = mi.node.next[0]
+	} else {
+		mi.node = mi.node.prev
+	}
 }
 
 func (mi *memIterator) Key() []byte {
 	mi.assertValid()
-	return mi.items[0].Key
+	return mi.node.key
 }
 
 func (mi *memIterator) Value() []byte {
 	mi.assertValid()
-	return mi.items[0].Value
+	return mi.cache[string(mi.node.key)].value
 }
 
 func (mi *memIterator) Close() {
 	mi.start = nil
 	mi.end = nil
-	mi.items = nil
-}
-
-//----------------------------------------
-// Misc.
-
-// bytes.Compare but bounded on both sides by nil.
-// both (k1, nil) and (nil, k2) return -1
-func keyCompare(k1, k2 []byte) int {
-	if k1 == nil && k2 == nil {
-		return 0
-	} else if k1 == nil || k2 == nil {
-		return -1
-	}
-	return bytes.Compare(k1, k2)
+	mi.node = nil
+	mi.cache = nil
 }
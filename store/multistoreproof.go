@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/ripemd160"
+
+	"github.com/tendermint/iavl"
+	cmn "github.com/tendermint/tmlibs/common"
+	"github.com/tendermint/tmlibs/merkle"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// multiStoreProof proves a key (or its absence) in a substore
+// up to the commit hash of the rootMultiStore, i.e. the AppHash.
+type multiStoreProof struct {
+
+	// The store the key belongs to, and its CommitID,
+	// which is proven by the simple merkle proof below.
+	StoreInfo storeInfo
+
+	// Simple merkle proof of the store's KVPair leaf in the
+	// SimpleMap of the commitInfo, see commitInfo.Hash().
+	Index int
+	Total int
+	Aunts [][]byte
+
+	// Proof of the key in the substore, e.g. an IAVL KeyProof.
+	StoreProof []byte
+}
+
+// Returns false if the store is not in the commitInfo.
+func newMultiStoreProof(cInfo commitInfo, storeName string, storeProof []byte) (multiStoreProof, bool) {
+	sm := merkle.NewSimpleMap()
+	var info storeInfo
+	found := false
+	for _, storeInfo := range cInfo.StoreInfos {
+		sm.Set(storeInfo.Name, storeInfo)
+		if storeInfo.Name == storeName {
+			info, found = storeInfo, true
+		}
+	}
+	if !found {
+		return multiStoreProof{}, false
+	}
+
+	// The leaves of the SimpleMap, sorted by key hash.
+	kvs := sm.KVPairs()
+	leaves := make([]merkle.Hasher, len(kvs))
+	for i, kv := range kvs {
+		leaves[i] = kvPair(kv)
+	}
+	_, proofs := merkle.SimpleProofsFromHashers(leaves)
+	keyHash := merkle.SimpleHashFromBytes([]byte(storeName))
+	for i, kv := range kvs {
+		if !bytes.Equal(kv.Key, keyHash) {
+			continue
+		}
+		return multiStoreProof{
+			StoreInfo:  info,
+			Index:      i,
+			Total:      len(leaves),
+			Aunts:      proofs[i].Aunts,
+			StoreProof: storeProof,
+		}, true
+	}
+	return multiStoreProof{}, false
+}
+
+// Bytes returns the go-wire encoded proof, as returned in abci.ResponseQuery.Proof.
+func (proof multiStoreProof) Bytes() []byte {
+	bz, err := cdc.MarshalBinary(proof)
+	if err != nil {
+		panic(err)
+	}
+	return bz
+}
+
+// VerifyMultiStoreProof verifies a proof returned by a rootMultiStore "/key"
+// query with Prove set, against the commit hash of the query height.
+// The value must be nil to verify that the key is absent from the store.
+// Only proofs of IAVL substores are supported.
+func VerifyMultiStoreProof(proofBytes []byte, commitHash []byte, storeName string, key, value []byte) error {
+	proof, storeHash, err := verifyStoreInfo(proofBytes, commitHash, storeName)
+	if err != nil {
+		return err
+	}
+
+	// Prove the key up to the store's hash.
+	keyProof, err := iavl.ReadKeyProof(proof.StoreProof)
+	if err != nil {
+		return fmt.Errorf("Failed to decode store proof: %v", err)
+	}
+	if !bytes.Equal(keyProof.Root(), storeHash) {
+		return fmt.Errorf("Store proof root %X doesn't match store hash %X", keyProof.Root(), storeHash)
+	}
+	return keyProof.Verify(key, value, storeHash)
+}
+
+// VerifyMultiStoreRangeProof verifies a proof returned by a rootMultiStore
+// "/subspace" query with Prove set, against the commit hash of the query height.
+// The kvs must be all the pairs of the store with the subspace prefix, in order,
+// so an empty kvs verifies that the subspace is empty.
+// Only proofs of IAVL substores are supported.
+func VerifyMultiStoreRangeProof(proofBytes []byte, commitHash []byte, storeName string, subspace []byte, kvs []cmn.KVPair) error {
+	proof, storeHash, err := verifyStoreInfo(proofBytes, commitHash, storeName)
+	if err != nil {
+		return err
+	}
+
+	// Prove the pairs up to the store's hash.
+	var rangeProof iavl.KeyRangeProof
+	err = cdc.UnmarshalBinary(proof.StoreProof, &rangeProof)
+	if err != nil {
+		return fmt.Errorf("Failed to decode store range proof: %v", err)
+	}
+	keys := make([][]byte, len(kvs))
+	values := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		keys[i], values[i] = kv.Key, kv.Value
+	}
+	end := sdk.PrefixEndBytes(subspace)
+	return rangeProof.Verify(subspace, end, 0, keys, values, storeHash)
+}
+
+// Decodes the multiStoreProof, and proves its store's CommitID up to the
+// commit hash. Returns the proof and the hash of the store.
+func verifyStoreInfo(proofBytes []byte, commitHash []byte, storeName string) (proof multiStoreProof, storeHash []byte, err error) {
+	err = cdc.UnmarshalBinary(proofBytes, &proof)
+	if err != nil {
+		return proof, nil, fmt.Errorf("Failed to decode multistore proof: %v", err)
+	}
+	if proof.StoreInfo.Name != storeName {
+		return proof, nil, fmt.Errorf("Proof is for store %s, not %s", proof.StoreInfo.Name, storeName)
+	}
+	simpleProof := merkle.SimpleProof{Aunts: proof.Aunts}
+	leaf := kvPair{
+		Key:   merkle.SimpleHashFromBytes([]byte(storeName)),
+		Value: proof.StoreInfo.Hash(),
+	}
+	if !simpleProof.Verify(proof.Index, proof.Total, leaf.Hash(), commitHash) {
+		return proof, nil, fmt.Errorf("Invalid proof of store %s for commit hash %X", storeName, commitHash)
+	}
+	return proof, proof.StoreInfo.Core.CommitID.Hash, nil
+}
+
+//----------------------------------------
+
+// kvPair is a leaf of a merkle.SimpleMap, the hash of the key and the
+// hash of the value.  It hashes the same as the SimpleMap's own leaves,
+// which aren't exported.
+type kvPair cmn.KVPair
+
+// Implements merkle.Hasher.
+func (kv kvPair) Hash() []byte {
+	hasher := ripemd160.New()
+	writeByteSlice(hasher, kv.Key)
+	writeByteSlice(hasher, kv.Value)
+	return hasher.Sum(nil)
+}
+
+// Writes the uvarint length prefixed byte slice, as merkle.SimpleMap does.
+func writeByteSlice(w io.Writer, bz []byte) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(bz)))
+	w.Write(buf[:n]) // Does not error
+	w.Write(bz)
+}
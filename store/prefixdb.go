@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+
+	dbm "github.com/tendermint/tmlibs/db"
+)
+
+// prefixDB is a namespace of an underlying db, like a prefixStore, so
+// that an IAVL tree can be kept in the db of the rootMultiStore.
+// Implements dbm.DB.
+type prefixDB struct {
+	*prefixStore
+	db dbm.DB
+}
+
+var _ dbm.DB = &prefixDB{}
+
+// Returns a db holding the keys of db which start with prefix,
+// with the prefix stripped.
+func newPrefixDB(db dbm.DB, prefix []byte) *prefixDB {
+	return &prefixDB{
+		prefixStore: NewPrefixStore(dbStoreAdapter{db}, prefix),
+		db:          db,
+	}
+}
+
+// Implements dbm.DB.
+func (pdb *prefixDB) SetSync(key, value []byte) {
+	pdb.db.SetSync(pdb.key(key), value)
+}
+
+// Implements dbm.DB.
+func (pdb *prefixDB) DeleteSync(key []byte) {
+	pdb.db.DeleteSync(pdb.key(key))
+}
+
+// Implements dbm.DB.
+// The underlying db is left open, for its other namespaces.
+func (pdb *prefixDB) Close() {}
+
+// Implements dbm.DB.
+func (pdb *prefixDB) NewBatch() dbm.Batch {
+	return prefixBatch{pdb, pdb.db.NewBatch()}
+}
+
+// Implements dbm.DB.
+func (pdb *prefixDB) Print() {
+	iter := pdb.Iterator(nil, nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		fmt.Printf("[%X]:\t[%X]\n", iter.Key(), iter.Value())
+	}
+}
+
+// Implements dbm.DB.
+func (pdb *prefixDB) Stats() map[string]string {
+	return pdb.db.Stats()
+}
+
+//----------------------------------------
+
+// prefixBatch prefixes the keys of the writes to a prefixDB.
+// Implements dbm.Batch.
+type prefixBatch struct {
+	pdb   *prefixDB
+	batch dbm.Batch
+}
+
+// Implements dbm.Batch.
+func (pb prefixBatch) Set(key, value []byte) {
+	pb.batch.Set(pb.pdb.key(key), value)
+}
+
+// Implements dbm.Batch.
+func (pb prefixBatch) Delete(key []byte) {
+	pb.batch.Delete(pb.pdb.key(key))
+}
+
+// Implements dbm.Batch.
+func (pb prefixBatch) Write() {
+	pb.batch.Write()
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"bytes"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// prefixStore is a namespace of an underlying KVStore.
+// All the keys are transparently prefixed in the parent.
+// Implements KVStore.
+type prefixStore struct {
+	parent KVStore
+	prefix []byte
+}
+
+var _ KVStore = &prefixStore{}
+
+// NewPrefixStore returns a KVStore holding the keys of parent
+// which start with prefix, with the prefix stripped.
+func NewPrefixStore(parent KVStore, prefix []byte) *prefixStore {
+	return &prefixStore{
+		parent: parent,
+		prefix: cp(prefix),
+	}
+}
+
+// Implements Store.
+func (ps *prefixStore) GetStoreType() StoreType {
+	return ps.parent.GetStoreType()
+}
+
+// Implements Store.
+func (ps *prefixStore) CacheWrap() CacheWrap {
+	return NewCacheKVStore(ps)
+}
+
+// Implements KVStore.
+func (ps *prefixStore) Get(key []byte) []byte {
+	return ps.parent.Get(ps.key(key))
+}
+
+// Implements KVStore.
+func (ps *prefixStore) Has(key []byte) bool {
+	return ps.parent.Has(ps.key(key))
+}
+
+// Implements KVStore.
+func (ps *prefixStore) Set(key, value []byte) {
+	ps.parent.Set(ps.key(key), value)
+}
+
+// Implements KVStore.
+func (ps *prefixStore) Delete(key []byte) {
+	ps.parent.Delete(ps.key(key))
+}
+
+// Implements KVStore.
+func (ps *prefixStore) Iterator(start, end []byte) Iterator {
+	pstart := ps.prefix
+	if start != nil {
+		pstart = ps.key(start)
+	}
+	pend := sdk.PrefixEndBytes(ps.prefix)
+	if end != nil {
+		pend = ps.key(end)
+	}
+	parent := ps.parent.Iterator(pstart, pend)
+	return newPrefixIterator(ps.prefix, start, end, parent)
+}
+
+// Implements KVStore.
+func (ps *prefixStore) ReverseIterator(start, end []byte) Iterator {
+	pstart := sdk.PrefixEndBytes(ps.prefix)
+	if start != nil {
+		pstart = ps.key(start)
+	}
+	// NOTE: end is exclusive, so the lower bound can't be the prefix itself,
+	// or the key equal to the prefix would be skipped. The prefixIterator
+	// stops at the first key below the prefix instead.
+	var pend []byte
+	if end != nil {
+		pend = ps.key(end)
+	}
+	parent := ps.parent.ReverseIterator(pstart, pend)
+	return newPrefixIterator(ps.prefix, start, end, parent)
+}
+
+// Implements KVStore.
+func (ps *prefixStore) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, ps)
+}
+
+// Returns the key prefixed, for the parent.
+func (ps *prefixStore) key(key []byte) []byte {
+	if key == nil {
+		panic("key is nil")
+	}
+	pkey := make([]byte, len(ps.prefix)+len(key))
+	copy(pkey, ps.prefix)
+	copy(pkey[len(ps.prefix):], key)
+	return pkey
+}
+
+//----------------------------------------
+
+// prefixIterator strips the prefix from the keys of the parent iterator,
+// and is invalid once the parent reaches a key without the prefix.
+// Implements Iterator.
+type prefixIterator struct {
+	prefix     []byte
+	start, end []byte
+	parent     Iterator
+}
+
+func newPrefixIterator(prefix, start, end []byte, parent Iterator) *prefixIterator {
+	// The inclusive upper bound of a reverse iteration
+	// may be the first key after the prefix.
+	if parent.Valid() && !bytes.HasPrefix(parent.Key(), prefix) {
+		parent.Next()
+	}
+	return &prefixIterator{
+		prefix: prefix,
+		start:  start,
+		end:    end,
+		parent: parent,
+	}
+}
+
+// Implements Iterator.
+func (pi *prefixIterator) Domain() (start []byte, end []byte) {
+	return pi.start, pi.end
+}
+
+// Implements Iterator.
+func (pi *prefixIterator) Valid() bool {
+	return pi.parent.Valid() && bytes.HasPrefix(pi.parent.Key(), pi.prefix)
+}
+
+// Implements Iterator.
+func (pi *prefixIterator) Next() {
+	pi.assertValid()
+	pi.parent.Next()
+}
+
+// Implements Iterator.
+func (pi *prefixIterator) Key() []byte {
+	pi.assertValid()
+	return pi.parent.Key()[len(pi.prefix):]
+}
+
+// Implements Iterator.
+func (pi *prefixIterator) Value() []byte {
+	pi.assertValid()
+	return pi.parent.Value()
+}
+
+// Implements Iterator.
+func (pi *prefixIterator) Close() {
+	pi.parent.Close()
+}
+
+func (pi *prefixIterator) assertValid() {
+	if !pi.Valid() {
+		panic("prefixIterator is invalid")
+	}
+}
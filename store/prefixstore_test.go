@@ -0,0 +1,123 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tendermint/iavl"
+	dbm "github.com/tendermint/tmlibs/db"
+)
+
+func TestPrefixStoreGetSet(t *testing.T) {
+	parent := dbStoreAdapter{dbm.NewMemDB()}
+	pstore := NewPrefixStore(parent, bz("p/"))
+
+	pstore.Set(bz("key"), bz("value"))
+	assert.Equal(t, bz("value"), pstore.Get(bz("key")))
+	assert.True(t, pstore.Has(bz("key")))
+	assert.Equal(t, bz("value"), parent.Get(bz("p/key")))
+	assert.Nil(t, parent.Get(bz("key")))
+
+	pstore.Delete(bz("key"))
+	assert.False(t, pstore.Has(bz("key")))
+	assert.Nil(t, parent.Get(bz("p/key")))
+}
+
+// Returns parents of each kind holding the same keys, the cacheKVStore
+// with some of them only in its cache, and others deleted in it.
+func newPrefixStoreParents() map[string]KVStore {
+	keys := []string{"a", "p", "p/", "p/a", "p/b", "p/c", "p0", "q"}
+
+	db := dbStoreAdapter{dbm.NewMemDB()}
+	tree := newIAVLStore(iavl.NewVersionedTree(dbm.NewMemDB(), cacheSize), pruning)
+	for _, key := range keys {
+		db.Set(bz(key), bz(key))
+		tree.Set(bz(key), bz(key))
+	}
+	tree.Commit()
+
+	base := newIAVLStore(iavl.NewVersionedTree(dbm.NewMemDB(), cacheSize), pruning)
+	for _, key := range []string{"a", "p/", "p/0", "p/b", "p/d", "q"} {
+		base.Set(bz(key), bz(key))
+	}
+	base.Commit()
+	cache := NewCacheKVStore(base)
+	for _, key := range []string{"p", "p/a", "p/c", "p0"} {
+		cache.Set(bz(key), bz(key))
+	}
+	cache.Delete(bz("p/0"))
+	cache.Delete(bz("p/d"))
+
+	return map[string]KVStore{"db": db, "iavl": tree, "cache": cache}
+}
+
+func TestPrefixStoreIterator(t *testing.T) {
+	cases := []struct {
+		reverse    bool
+		start, end []byte
+		keys       []string
+	}{
+		{false, nil, nil, []string{"", "a", "b", "c"}},
+		{false, bz("a"), nil, []string{"a", "b", "c"}},
+		{false, nil, bz("c"), []string{"", "a", "b"}},
+		{false, bz("a"), bz("c"), []string{"a", "b"}},
+		{true, nil, nil, []string{"c", "b", "a", ""}},
+		{true, bz("b"), nil, []string{"b", "a", ""}},
+		{true, nil, bz("a"), []string{"c", "b"}},
+		{true, bz("c"), bz("a"), []string{"c", "b"}},
+	}
+
+	for name, parent := range newPrefixStoreParents() {
+		pstore := NewPrefixStore(parent, bz("p/"))
+		for i, tc := range cases {
+			var iter Iterator
+			if tc.reverse {
+				iter = pstore.ReverseIterator(tc.start, tc.end)
+			} else {
+				iter = pstore.Iterator(tc.start, tc.end)
+			}
+			keys := []string{}
+			for ; iter.Valid(); iter.Next() {
+				assert.Equal(t, "p/"+string(iter.Key()), string(iter.Value()))
+				keys = append(keys, string(iter.Key()))
+			}
+			iter.Close()
+			assert.Equal(t, tc.keys, keys, "%v parent, case %d", name, i)
+		}
+	}
+}
+
+func TestPrefixStoreIteratorMaxPrefix(t *testing.T) {
+	parent := dbStoreAdapter{dbm.NewMemDB()}
+	parent.Set([]byte{0xfe, 0xff}, bz("x"))
+	parent.Set([]byte{0xff, 0x00}, bz("0"))
+	parent.Set([]byte{0xff, 0xff}, bz("1"))
+	pstore := NewPrefixStore(parent, []byte{0xff})
+
+	iter := pstore.Iterator(nil, nil)
+	assert.Equal(t, []byte{0x00}, iter.Key())
+	iter.Next()
+	assert.Equal(t, []byte{0xff}, iter.Key())
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	iter = pstore.ReverseIterator(nil, nil)
+	assert.Equal(t, []byte{0xff}, iter.Key())
+	iter.Next()
+	assert.Equal(t, []byte{0x00}, iter.Key())
+	iter.Next()
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestPrefixStoreCacheWrap(t *testing.T) {
+	parent := dbStoreAdapter{dbm.NewMemDB()}
+	pstore := NewPrefixStore(parent, bz("p/"))
+
+	cache := pstore.CacheWrap().(CacheKVStore)
+	cache.Set(bz("key"), bz("value"))
+	assert.Nil(t, parent.Get(bz("p/key")))
+	cache.Write()
+	assert.Equal(t, bz("value"), parent.Get(bz("p/key")))
+}
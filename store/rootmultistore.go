@@ -2,12 +2,14 @@ package store
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
 	"golang.org/x/crypto/ripemd160"
 
 	abci "github.com/tendermint/abci/types"
 	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
 	"github.com/tendermint/tmlibs/merkle"
 
 	sdk "github.com/cosmos/cosmos-sdk/types"
@@ -15,7 +17,8 @@ import (
 
 const (
 	latestVersionKey = "s/latest"
-	commitInfoKeyFmt = "s/%d" // s/<version>
+	commitInfoKeyFmt = "s/%d"    // s/<version>
+	dbStoreKeyFmt    = "s/k:%s/" // s/k:<name>/, prefix of the keys of a store in our db
 )
 
 // rootMultiStore is composed of many CommitStores.
@@ -25,9 +28,15 @@ const (
 type rootMultiStore struct {
 	db           dbm.DB
 	lastCommitID CommitID
+	pruning      PruningOptions
 	storesParams map[StoreKey]storeParams
 	stores       map[StoreKey]CommitStore
 	keysByName   map[string]StoreKey
+
+	upgradeHeight int64
+	upgrades      StoreUpgrades
+
+	traceWriter *traceWriter
 }
 
 var _ CommitMultiStore = (*rootMultiStore)(nil)
@@ -55,13 +64,76 @@ func (rs *rootMultiStore) MountStoreWithDB(key StoreKey, typ StoreType, db dbm.D
 	if _, ok := rs.storesParams[key]; ok {
 		panic(fmt.Sprintf("rootMultiStore duplicate store key %v", key))
 	}
-	rs.storesParams[key] = storeParams{
+	params := storeParams{
 		db:  db,
 		typ: typ,
 	}
+	// A nested multistore is created right away, so that its
+	// substores can be mounted through GetCommitStore before loading.
+	// It needs its own db, as its commit records would collide with ours.
+	if typ == sdk.StoreTypeMulti {
+		if db == nil || db == rs.db {
+			panic(fmt.Sprintf("nested multistore %v needs its own db", key))
+		}
+		params.multi = NewCommitMultiStore(db)
+		rs.stores[key] = params.multi
+	}
+	// A db store is written in the same batch as our commit records.
+	if typ == sdk.StoreTypeDB {
+		if db != nil && db != rs.db {
+			panic(fmt.Sprintf("db store %v can't have its own db", key))
+		}
+		params.underName = true
+	}
+	// An IAVL store without its own db is kept under its name in ours.
+	// IAVL stores can't share any other db, as each tree has its own
+	// version roots.
+	if typ == sdk.StoreTypeIAVL {
+		if db == nil || db == rs.db {
+			params.db = newPrefixDB(rs.db, []byte(fmt.Sprintf(dbStoreKeyFmt, key.Name())))
+			params.underName = true
+		}
+		for key2, params2 := range rs.storesParams {
+			if params2.typ == sdk.StoreTypeIAVL && params2.db == params.db {
+				panic(fmt.Sprintf("IAVL store %v can't share the db of IAVL store %v", key, key2))
+			}
+		}
+	}
+	rs.storesParams[key] = params
 	rs.keysByName[key.Name()] = key
 }
 
+// Implements CommitMultiStore.
+func (rs *rootMultiStore) SetPruning(pruning PruningOptions) {
+	rs.pruning = pruning
+}
+
+// Implements CommitMultiStore.
+func (rs *rootMultiStore) SetStoreUpgrades(height int64, upgrades StoreUpgrades) {
+	rs.upgradeHeight = height
+	rs.upgrades = upgrades
+}
+
+// Implements MultiStore.
+// The cache-wraps made afterwards share the tracer.
+func (rs *rootMultiStore) SetTracer(w io.Writer, logger log.Logger) {
+	rs.traceWriter = newTraceWriter(w, logger)
+}
+
+// Implements MultiStore.
+func (rs *rootMultiStore) TracingEnabled() bool {
+	return rs.traceWriter != nil
+}
+
+// Implements MultiStore.
+func (rs *rootMultiStore) GetTracedKVStore(key StoreKey, tc TraceContext) KVStore {
+	store := rs.GetKVStore(key)
+	if rs.TracingEnabled() {
+		store = NewTraceKVStore(store, key.Name(), rs.traceWriter, nil, tc)
+	}
+	return store
+}
+
 // Implements CommitMultiStore.
 func (rs *rootMultiStore) GetCommitStore(key StoreKey) CommitStore {
 	return rs.stores[key]
@@ -80,7 +152,7 @@ func (rs *rootMultiStore) LoadVersion(ver int64) error {
 	if ver == 0 {
 		for key, storeParams := range rs.storesParams {
 			id := CommitID{}
-			store, err := rs.loadCommitStoreFromParams(id, storeParams)
+			store, err := rs.loadCommitStoreFromParams(key, id, storeParams)
 			if err != nil {
 				return fmt.Errorf("Failed to load rootMultiStore: %v", err)
 			}
@@ -91,6 +163,10 @@ func (rs *rootMultiStore) LoadVersion(ver int64) error {
 		return nil
 	}
 	// Otherwise, version is 1 or greater
+	err := rs.checkUpgrades(ver)
+	if err != nil {
+		return err
+	}
 
 	// Get commitInfo
 	cInfo, err := getCommitInfo(rs.db, ver)
@@ -101,20 +177,56 @@ func (rs *rootMultiStore) LoadVersion(ver int64) error {
 	// Load each Store
 	var newStores = make(map[StoreKey]CommitStore)
 	for _, storeInfo := range cInfo.StoreInfos {
-		key, commitID := rs.nameToKey(storeInfo.Name), storeInfo.Core.CommitID
-		storeParams := rs.storesParams[key]
-		store, err := rs.loadCommitStoreFromParams(commitID, storeParams)
+		key, err := rs.nameToKey(storeInfo.Name, ver)
+		if err != nil {
+			return fmt.Errorf("Failed to load rootMultiStore: %v", err)
+		}
+		if key == nil {
+			continue // deleted by the upgrades
+		}
+		if ver < rs.upgradeHeight && rs.upgrades.IsAdded(key.Name()) {
+			return fmt.Errorf("Store %v is added by the upgrades, but was committed before", key.Name())
+		}
+		commitID, storeParams := storeInfo.Core.CommitID, rs.storesParams[key]
+
+		// A db store only holds the latest version.
+		if storeParams.typ == sdk.StoreTypeDB && ver != getLatestVersion(rs.db) {
+			return fmt.Errorf("Cannot load version %v of db store %v, it keeps no history", ver, storeInfo.Name)
+		}
+
+		// The data of a store in our db is under its name.
+		if storeParams.underName && key.Name() != storeInfo.Name {
+			rs.renameStoreData(storeInfo.Name, key.Name())
+		}
+
+		store, err := rs.loadCommitStoreFromParams(key, commitID, storeParams)
 		if err != nil {
 			return fmt.Errorf("Failed to load rootMultiStore: %v", err)
 		}
 		newStores[key] = store
 	}
 
-	// If any CommitStoreLoaders were not used, return error.
-	for key := range rs.storesParams {
-		if _, ok := newStores[key]; !ok {
+	// Transient stores aren't committed, so they start out empty.
+	for key, storeParams := range rs.storesParams {
+		if storeParams.typ == sdk.StoreTypeTransient {
+			newStores[key], _ = rs.loadCommitStoreFromParams(key, CommitID{}, storeParams)
+		}
+	}
+
+	// Stores added by the upgrades start out empty.
+	// If any other CommitStoreLoaders were not used, return error.
+	for key, storeParams := range rs.storesParams {
+		if _, ok := newStores[key]; ok {
+			continue
+		}
+		if ver >= rs.upgradeHeight || !rs.upgrades.IsAdded(key.Name()) {
 			return fmt.Errorf("Unused CommitStoreLoader: %v", key)
 		}
+		store, err := rs.loadCommitStoreFromParams(key, CommitID{}, storeParams)
+		if err != nil {
+			return fmt.Errorf("Failed to load rootMultiStore: %v", err)
+		}
+		newStores[key] = store
 	}
 
 	// Success.
@@ -123,6 +235,73 @@ func (rs *rootMultiStore) LoadVersion(ver int64) error {
 	return nil
 }
 
+// Implements CommitMultiStore.
+// The commit records are rewritten first, in one batch, and then the
+// later versions of each store are deleted, so that a rollback that was
+// interrupted can simply be run again.
+func (rs *rootMultiStore) RollbackToVersion(ver int64) error {
+	latest := getLatestVersion(rs.db)
+	if ver <= 0 || ver > latest {
+		return fmt.Errorf("Cannot roll back to version %v, the latest version is %v", ver, latest)
+	}
+	err := rs.checkUpgrades(ver)
+	if err != nil {
+		return err
+	}
+	cInfo, err := getCommitInfo(rs.db, ver)
+	if err != nil {
+		return err
+	}
+
+	// Check that every store can be rolled back, before touching any.
+	keys := make([]StoreKey, len(cInfo.StoreInfos))
+	for i, storeInfo := range cInfo.StoreInfos {
+		key, err := rs.nameToKey(storeInfo.Name, ver)
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+		if key == nil {
+			continue // deleted by the upgrades
+		}
+		if rs.storesParams[key].typ == sdk.StoreTypeDB {
+			return fmt.Errorf("Cannot roll back db store %v, it keeps no history", storeInfo.Name)
+		}
+	}
+
+	// Point to the version atomically.
+	batch := rs.db.NewBatch()
+	for v := ver + 1; v <= latest; v++ {
+		deleteCommitInfo(batch, v)
+	}
+	setLatestVersion(batch, ver)
+	batch.Write()
+
+	// Delete the later versions of each store.
+	for i, storeInfo := range cInfo.StoreInfos {
+		key, commitID := keys[i], storeInfo.Core.CommitID
+		if key == nil {
+			continue
+		}
+		params := rs.storesParams[key]
+		db := rs.db
+		if params.db != nil {
+			db = params.db
+		}
+		switch params.typ {
+		case sdk.StoreTypeMulti:
+			err = params.multi.RollbackToVersion(commitID.Version)
+		case sdk.StoreTypeIAVL:
+			err = rollbackIAVLStore(db, commitID.Version)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to roll back store %v: %v", storeInfo.Name, err)
+		}
+	}
+
+	return rs.LoadVersion(ver)
+}
+
 //----------------------------------------
 // +CommitStore
 
@@ -142,6 +321,19 @@ func (rs *rootMultiStore) Commit() CommitID {
 	batch := rs.db.NewBatch()
 	setCommitInfo(batch, version, commitInfo)
 	setLatestVersion(batch, version)
+
+	// The writes of the db stores are committed along with the commitInfo.
+	for _, store := range rs.stores {
+		if st, ok := store.(*dbStore); ok {
+			st.writeBatch(batch)
+		}
+	}
+
+	// Prune the commitInfo the same way the stores are pruned.
+	toRelease := version - rs.pruning.KeepRecent
+	if rs.pruning.Prunes(toRelease, version) {
+		deleteCommitInfo(batch, toRelease)
+	}
 	batch.Write()
 
 	// Prepare for next version.
@@ -166,6 +358,71 @@ func (rs *rootMultiStore) CacheMultiStore() CacheMultiStore {
 	return newCacheMultiStoreFromRMS(rs)
 }
 
+// Implements CommitMultiStore.
+// The stores are loaded anew at the given version, so they don't
+// share any state with the latest stores.  Past versions of db stores
+// are not kept, so loading a past version with one returns an error.  Before the store upgrades,
+// the stores are found by their new names, and the added stores are
+// missing.
+func (rs *rootMultiStore) CacheMultiStoreWithVersion(ver int64) (CacheMultiStore, error) {
+	if ver == rs.lastCommitID.Version {
+		return rs.CacheMultiStore(), nil
+	}
+	if ver <= 0 || ver > rs.lastCommitID.Version {
+		return nil, fmt.Errorf("Version %v is not a committed version", ver)
+	}
+
+	// Get commitInfo
+	cInfo, err := getCommitInfo(rs.db, ver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load each Store
+	var stores = make(map[StoreKey]CacheWrapper)
+	for _, storeInfo := range cInfo.StoreInfos {
+		key, err := rs.nameToKey(storeInfo.Name, ver)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load version %v: %v", ver, err)
+		}
+		if key == nil {
+			continue // deleted by the upgrades
+		}
+		commitID, storeParams := storeInfo.Core.CommitID, rs.storesParams[key]
+
+		// A db store only holds the latest version.
+		if storeParams.typ == sdk.StoreTypeDB {
+			return nil, fmt.Errorf("Cannot load version %v of db store %v, it keeps no history", ver, storeInfo.Name)
+		}
+
+		// A nested multistore loads its own past version.
+		if storeParams.typ == sdk.StoreTypeMulti {
+			cms, err := storeParams.multi.CacheMultiStoreWithVersion(commitID.Version)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load version %v: %v", ver, err)
+			}
+			stores[key] = cms
+			continue
+		}
+
+		store, err := rs.loadCommitStoreFromParams(key, commitID, storeParams)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load version %v: %v", ver, err)
+		}
+		stores[key] = store
+	}
+
+	// Transient stores are empty in any past version.
+	for key, storeParams := range rs.storesParams {
+		if storeParams.typ == sdk.StoreTypeTransient {
+			stores[key], _ = rs.loadCommitStoreFromParams(key, CommitID{}, storeParams)
+		}
+	}
+
+	// Past versions are not traced.
+	return newCacheMultiStoreFromStores(rs.db, stores, rs.keysByName, nil), nil
+}
+
 // Implements MultiStore.
 func (rs *rootMultiStore) GetStore(key StoreKey) Store {
 	return rs.stores[key]
@@ -194,7 +451,8 @@ func (rs *rootMultiStore) getStoreByName(name string) Store {
 // Query calls substore.Query with the same `req` where `req.Path` is
 // modified to remove the substore prefix.
 // Ie. `req.Path` here is `/<substore>/<path>`, and trimmed to `/<path>` for the substore.
-// TODO: add proof for `multistore -> substore`.
+// If `req.Prove` is set, the substore proof is wrapped in a multiStoreProof,
+// which proves the substore up to the commit hash, see VerifyMultiStoreProof.
 func (rs *rootMultiStore) Query(req abci.RequestQuery) abci.ResponseQuery {
 	// Query just routes this to a substore.
 	path := req.Path
@@ -217,6 +475,21 @@ func (rs *rootMultiStore) Query(req abci.RequestQuery) abci.ResponseQuery {
 	// trim the path and make the query
 	req.Path = subpath
 	res := queryable.Query(req)
+	if !req.Prove || res.Code != uint32(sdk.CodeOK) || len(res.Proof) == 0 {
+		return res
+	}
+
+	// prove the substore up to the commit hash at the same height
+	cInfo, err2 := getCommitInfo(rs.db, res.Height)
+	if err2 != nil {
+		return sdk.ErrInternal(err2.Error()).Result().ToQuery()
+	}
+	proof, ok := newMultiStoreProof(cInfo, storeName, res.Proof)
+	if !ok {
+		msg := fmt.Sprintf("store %s is not in the commit at height %d", storeName, res.Height)
+		return sdk.ErrInternal(msg).Result().ToQuery()
+	}
+	res.Proof = proof.Bytes()
 	return res
 }
 
@@ -238,41 +511,85 @@ func parsePath(path string) (storeName string, subpath string, err sdk.Error) {
 
 //----------------------------------------
 
-func (rs *rootMultiStore) loadCommitStoreFromParams(id CommitID, params storeParams) (store CommitStore, err error) {
+func (rs *rootMultiStore) loadCommitStoreFromParams(key StoreKey, id CommitID, params storeParams) (store CommitStore, err error) {
 	db := rs.db
 	if params.db != nil {
 		db = params.db
 	}
 	switch params.typ {
 	case sdk.StoreTypeMulti:
-		panic("recursive MultiStores not yet supported")
-		// TODO: id?
-		// return NewCommitMultiStore(db, id)
+		params.multi.SetPruning(rs.pruning)
+		err = params.multi.LoadVersion(id.Version)
+		store = params.multi
+		return
 	case sdk.StoreTypeIAVL:
-		store, err = LoadIAVLStore(db, id)
+		store, err = LoadIAVLStore(db, id, rs.pruning)
 		return
 	case sdk.StoreTypeDB:
-		panic("dbm.DB is not a CommitStore")
+		prefix := []byte(fmt.Sprintf(dbStoreKeyFmt, key.Name()))
+		store = newDBStore(db, prefix, id)
+		return
+	case sdk.StoreTypeTransient:
+		store = newTransientStore()
+		return
 	default:
 		panic(fmt.Sprintf("unrecognized store type %v", params.typ))
 	}
 }
 
-func (rs *rootMultiStore) nameToKey(name string) StoreKey {
-	for key, _ := range rs.storesParams {
-		if key.Name() == name {
-			return key
+// Returns the key of the store committed under name in version ver,
+// following the store upgrades, or nil if the upgrades deleted it.
+func (rs *rootMultiStore) nameToKey(name string, ver int64) (StoreKey, error) {
+	if ver < rs.upgradeHeight {
+		if rs.upgrades.IsDeleted(name) {
+			return nil, nil
 		}
+		if newName, ok := rs.upgrades.Renamed[name]; ok {
+			name = newName
+		}
+	}
+	key, ok := rs.keysByName[name]
+	if !ok {
+		return nil, fmt.Errorf("No store mounted for %v", name)
 	}
-	panic("Unknown name " + name)
+	return key, nil
+}
+
+// The store upgrades only apply on top of the version right before
+// them, so that all nodes upgrade from the same commitInfo.
+func (rs *rootMultiStore) checkUpgrades(ver int64) error {
+	if ver < rs.upgradeHeight-1 {
+		return fmt.Errorf("Cannot load version %v, the store upgrades at height %v apply to version %v",
+			ver, rs.upgradeHeight, rs.upgradeHeight-1)
+	}
+	return nil
+}
+
+// Moves the data of a renamed store in our db under its new name.
+// Moving again is a no-op, e.g. if the node restarts before committing.
+func (rs *rootMultiStore) renameStoreData(oldName, newName string) {
+	db := rs.db
+	oldPrefix := []byte(fmt.Sprintf(dbStoreKeyFmt, oldName))
+	newPrefix := []byte(fmt.Sprintf(dbStoreKeyFmt, newName))
+	batch := db.NewBatch()
+	iter := db.Iterator(oldPrefix, sdk.PrefixEndBytes(oldPrefix))
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		batch.Set(append(cp(newPrefix), key[len(oldPrefix):]...), iter.Value())
+		batch.Delete(key)
+	}
+	iter.Close()
+	batch.Write()
 }
 
 //----------------------------------------
 // storeParams
 
 type storeParams struct {
-	db  dbm.DB
-	typ StoreType
+	db        dbm.DB
+	typ       StoreType
+	multi     CommitMultiStore // only for StoreTypeMulti
+	underName bool             // the data is under the name of the store in our db
 }
 
 //----------------------------------------
@@ -362,6 +679,11 @@ func commitStores(version int64, storeMap map[StoreKey]CommitStore) commitInfo {
 		// Commit
 		commitID := store.Commit()
 
+		// Transient stores are wiped by the Commit, and aren't recorded.
+		if store.GetStoreType() == sdk.StoreTypeTransient {
+			continue
+		}
+
 		// Record CommitID
 		si := storeInfo{}
 		si.Name = key.Name()
@@ -404,3 +726,9 @@ func setCommitInfo(batch dbm.Batch, version int64, cInfo commitInfo) {
 	cInfoKey := fmt.Sprintf(commitInfoKeyFmt, version)
 	batch.Set([]byte(cInfoKey), cInfoBytes)
 }
+
+// Delete the commitInfo of given version.
+func deleteCommitInfo(batch dbm.Batch, version int64) {
+	cInfoKey := fmt.Sprintf(commitInfoKeyFmt, version)
+	batch.Delete([]byte(cInfoKey))
+}
@@ -1,10 +1,12 @@
 package store
 
 import (
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	abci "github.com/tendermint/abci/types"
+	cmn "github.com/tendermint/tmlibs/common"
 	dbm "github.com/tendermint/tmlibs/db"
 	"github.com/tendermint/tmlibs/merkle"
 
@@ -71,6 +73,365 @@ func TestMultistoreCommitLoad(t *testing.T) {
 	checkStore(t, store, commitID, commitID)
 }
 
+func TestMultistorePruning(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := NewCommitMultiStore(db)
+	store.MountStoreWithDB(sdk.NewKVStoreKey("store1"), sdk.StoreTypeIAVL, dbm.NewMemDB())
+	store.SetPruning(sdk.PruningOptions{KeepRecent: 2, KeepEvery: 3})
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		store.Commit()
+	}
+
+	// commit infos are pruned like the stores
+	for ver := int64(1); ver <= 10; ver++ {
+		kept := ver >= 9 || ver%3 == 0
+		_, err := getCommitInfo(db, ver)
+		assert.Equal(t, kept, err == nil, "version %d", ver)
+		_, err = store.CacheMultiStoreWithVersion(ver)
+		assert.Equal(t, kept, err == nil, "version %d", ver)
+	}
+}
+
+func TestMultistoreTransient(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := newMultiStoreWithMounts(db)
+	tkey := sdk.NewTransientStoreKey("transient")
+	store.MountStoreWithDB(tkey, sdk.StoreTypeTransient, nil)
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	k, v := []byte("wind"), []byte("blows")
+	store.GetKVStore(tkey).Set(k, v)
+	assert.Equal(t, v, store.GetKVStore(tkey).Get(k))
+
+	// the transient store is wiped, and not part of the commit hash
+	commitID := store.Commit()
+	assert.Nil(t, store.GetKVStore(tkey).Get(k))
+	delete(store.stores, tkey)
+	checkStore(t, store, getExpectedCommitID(store, 1), commitID)
+
+	// it is loaded again, empty
+	store = newMultiStoreWithMounts(db)
+	store.MountStoreWithDB(tkey, sdk.StoreTypeTransient, nil)
+	err = store.LoadLatestVersion()
+	assert.Nil(t, err)
+	assert.Nil(t, store.GetKVStore(tkey).Get(k))
+}
+
+func TestMultistoreDBStore(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := newMultiStoreWithMounts(db)
+	dkey := sdk.NewKVStoreKey("index")
+	store.MountStoreWithDB(dkey, sdk.StoreTypeDB, nil)
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	k, v := []byte("wind"), []byte("blows")
+	store.GetKVStore(dkey).Set(k, v)
+
+	// the writes are buffered until the commit
+	assert.Equal(t, v, store.GetKVStore(dkey).Get(k))
+	assert.Nil(t, db.Get([]byte("s/k:index/wind")))
+
+	// the db store is committed with its version only
+	commitID := store.Commit()
+	assert.Equal(t, CommitID{Version: 1}, store.GetCommitStore(dkey).LastCommitID())
+	checkStore(t, store, getExpectedCommitID(store, 1), commitID)
+
+	// its keys are namespaced in the shared db
+	assert.Nil(t, db.Get(k))
+	assert.Equal(t, v, db.Get([]byte("s/k:index/wind")))
+
+	// it is loaded again, with its data
+	store = newMultiStoreWithMounts(db)
+	store.MountStoreWithDB(dkey, sdk.StoreTypeDB, nil)
+	err = store.LoadLatestVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, v, store.GetKVStore(dkey).Get(k))
+	checkStore(t, store, commitID, store.LastCommitID())
+
+	// queries are served without proofs
+	res := store.Query(abci.RequestQuery{Path: "/index/key", Data: k})
+	assert.Equal(t, uint32(sdk.CodeOK), res.Code, res.Log)
+	assert.Equal(t, v, res.Value)
+	res = store.Query(abci.RequestQuery{Path: "/index/key", Data: k, Prove: true})
+	assert.Equal(t, uint32(sdk.CodeUnknownRequest), res.Code)
+
+	// past versions aren't kept
+	store.Commit()
+	_, err = store.CacheMultiStoreWithVersion(1)
+	assert.NotNil(t, err)
+	_, err = store.CacheMultiStoreWithVersion(2)
+	assert.Nil(t, err)
+	store = newMultiStoreWithMounts(db)
+	store.MountStoreWithDB(dkey, sdk.StoreTypeDB, nil)
+	assert.NotNil(t, store.LoadVersion(1))
+}
+
+func TestMultistoreNested(t *testing.T) {
+	db, subDB := dbm.NewMemDB(), dbm.NewMemDB()
+	store := NewCommitMultiStore(db)
+	mkey, key := sdk.NewKVStoreKey("multi"), sdk.NewKVStoreKey("store1")
+	store.MountStoreWithDB(mkey, sdk.StoreTypeMulti, subDB)
+	sub := store.GetCommitStore(mkey).(CommitMultiStore)
+	sub.MountStoreWithDB(key, sdk.StoreTypeIAVL, nil)
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	k, v1, v2 := []byte("wind"), []byte("blows"), []byte("howls")
+	sub.GetKVStore(key).Set(k, v1)
+	store.Commit()
+	sub.GetKVStore(key).Set(k, v2)
+	commitID := store.Commit()
+
+	// the nested multistore is committed along with its parent
+	assert.Equal(t, int64(2), sub.LastCommitID().Version)
+	checkStore(t, store, getExpectedCommitID(store, 2), commitID)
+
+	// past versions of the nested stores can be read
+	cms, err := store.CacheMultiStoreWithVersion(1)
+	assert.Nil(t, err)
+	subCache := cms.GetStore(mkey).(MultiStore)
+	assert.Equal(t, v1, subCache.GetKVStore(key).Get(k))
+
+	// it is loaded again
+	store = NewCommitMultiStore(db)
+	store.MountStoreWithDB(mkey, sdk.StoreTypeMulti, subDB)
+	sub = store.GetCommitStore(mkey).(CommitMultiStore)
+	sub.MountStoreWithDB(key, sdk.StoreTypeIAVL, nil)
+	err = store.LoadLatestVersion()
+	assert.Nil(t, err)
+	checkStore(t, store, commitID, store.LastCommitID())
+	assert.Equal(t, v2, sub.GetKVStore(key).Get(k))
+
+	// a nested multistore can't share the parent db
+	assert.Panics(t, func() {
+		store.MountStoreWithDB(sdk.NewKVStoreKey("other"), sdk.StoreTypeMulti, nil)
+	})
+}
+
+func TestMultistoreIAVLDBs(t *testing.T) {
+	db := dbm.NewMemDB()
+	store := newMultiStoreWithMounts(db)
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	// the IAVL stores on the root db are kept apart, under their names
+	k := []byte("wind")
+	for i, name := range []string{"store1", "store2", "store3"} {
+		store.getStoreByName(name).(KVStore).Set(k, []byte{byte(i)})
+	}
+	commitID := store.Commit()
+	store.getStoreByName("store1").(KVStore).Set(k, []byte("new"))
+	store.Commit()
+
+	store = newMultiStoreWithMounts(db)
+	err = store.LoadVersion(1)
+	assert.Nil(t, err)
+	checkStore(t, store, commitID, store.LastCommitID())
+	for i, name := range []string{"store1", "store2", "store3"} {
+		assert.Equal(t, []byte{byte(i)}, store.getStoreByName(name).(KVStore).Get(k))
+	}
+
+	// IAVL stores can't share any other db
+	iavlDB := dbm.NewMemDB()
+	store = NewCommitMultiStore(db)
+	store.MountStoreWithDB(sdk.NewKVStoreKey("store1"), sdk.StoreTypeIAVL, iavlDB)
+	assert.Panics(t, func() {
+		store.MountStoreWithDB(sdk.NewKVStoreKey("store2"), sdk.StoreTypeIAVL, iavlDB)
+	})
+}
+
+func TestMultistoreRollback(t *testing.T) {
+	db, iavlDB := dbm.NewMemDB(), dbm.NewMemDB()
+	key := sdk.NewKVStoreKey("store1")
+	newStore := func() *rootMultiStore {
+		store := NewCommitMultiStore(db)
+		store.MountStoreWithDB(key, sdk.StoreTypeIAVL, iavlDB)
+		return store
+	}
+	store := newStore()
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	k := []byte("wind")
+	commitIDs := make([]CommitID, 5)
+	for i := range commitIDs {
+		store.GetKVStore(key).Set(k, []byte{byte(i)})
+		commitIDs[i] = store.Commit()
+	}
+
+	// versions that were never committed can't be rolled back to
+	assert.NotNil(t, store.RollbackToVersion(0))
+	assert.NotNil(t, store.RollbackToVersion(6))
+
+	// roll back to version 3
+	err = store.RollbackToVersion(3)
+	assert.Nil(t, err)
+	assert.Equal(t, commitIDs[2], store.LastCommitID())
+	assert.Equal(t, []byte{2}, store.GetKVStore(key).Get(k))
+	_, err = store.CacheMultiStoreWithVersion(4)
+	assert.NotNil(t, err)
+
+	// the next commit is version 4 again, and persists
+	store.GetKVStore(key).Set(k, []byte("new"))
+	commitID := store.Commit()
+	assert.Equal(t, int64(4), commitID.Version)
+	assert.NotEqual(t, commitIDs[3], commitID)
+	store = newStore()
+	err = store.LoadLatestVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, commitID, store.LastCommitID())
+	assert.Equal(t, []byte("new"), store.GetKVStore(key).Get(k))
+
+	// it is also called instead of loading a version
+	store = newStore()
+	err = store.RollbackToVersion(2)
+	assert.Nil(t, err)
+	assert.Equal(t, commitIDs[1], store.LastCommitID())
+
+	// db stores keep no history
+	store = NewCommitMultiStore(dbm.NewMemDB())
+	store.MountStoreWithDB(sdk.NewKVStoreKey("index"), sdk.StoreTypeDB, nil)
+	err = store.LoadLatestVersion()
+	assert.Nil(t, err)
+	store.Commit()
+	store.Commit()
+	assert.NotNil(t, store.RollbackToVersion(1))
+}
+
+func TestMultistoreRollbackPruning(t *testing.T) {
+	db, iavlDB := dbm.NewMemDB(), dbm.NewMemDB()
+	key := sdk.NewKVStoreKey("store1")
+	newStore := func() *rootMultiStore {
+		store := NewCommitMultiStore(db)
+		store.MountStoreWithDB(key, sdk.StoreTypeIAVL, iavlDB)
+		store.SetPruning(sdk.PruningOptions{KeepRecent: 3})
+		return store
+	}
+	store := newStore()
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	// "a" changes in every version after the first
+	kvs := store.GetKVStore(key)
+	kvs.Set([]byte("a"), []byte{1})
+	kvs.Set([]byte("b"), []byte{1})
+	kvs.Set([]byte("c"), []byte{1})
+	store.Commit()
+	for i := 2; i <= 5; i++ {
+		store.GetKVStore(key).Set([]byte("a"), []byte{byte(i)})
+		store.Commit()
+	}
+
+	// roll back to version 3, and commit past the point where it is pruned,
+	// changing "b" instead, so that the nodes of "a" at 3 stay in the tree
+	err = store.RollbackToVersion(3)
+	assert.Nil(t, err)
+	for i := 4; i <= 7; i++ {
+		store.GetKVStore(key).Set([]byte("b"), []byte{byte(i)})
+		store.Commit()
+	}
+	_, err = store.CacheMultiStoreWithVersion(3)
+	assert.NotNil(t, err)
+
+	// the latest state is intact, read back from the db
+	store = newStore()
+	err = store.LoadLatestVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), store.LastCommitID().Version)
+	kvs = store.GetKVStore(key)
+	assert.Equal(t, []byte{3}, kvs.Get([]byte("a")))
+	assert.Equal(t, []byte{7}, kvs.Get([]byte("b")))
+	assert.Equal(t, []byte{1}, kvs.Get([]byte("c")))
+}
+
+func TestMultistoreUpgrades(t *testing.T) {
+	upgrades := StoreUpgrades{
+		Added:   []string{"store3"},
+		Renamed: map[string]string{"store2": "store4", "index": "index2"},
+		Deleted: []string{"store1"},
+	}
+	k, v := []byte("wind"), []byte("blows")
+
+	// Returns the commit after the upgrades, and checks the stores.
+	runUpgrade := func() CommitID {
+		db, db1, db2, db3 := dbm.NewMemDB(), dbm.NewMemDB(), dbm.NewMemDB(), dbm.NewMemDB()
+		key1, key2 := sdk.NewKVStoreKey("store1"), sdk.NewKVStoreKey("store2")
+		keyIndex := sdk.NewKVStoreKey("index")
+		store := NewCommitMultiStore(db)
+		store.MountStoreWithDB(key1, sdk.StoreTypeIAVL, db1)
+		store.MountStoreWithDB(key2, sdk.StoreTypeIAVL, db2)
+		store.MountStoreWithDB(keyIndex, sdk.StoreTypeDB, nil)
+		err := store.LoadLatestVersion()
+		assert.Nil(t, err)
+		store.GetKVStore(key1).Set(k, v)
+		store.GetKVStore(key2).Set(k, v)
+		store.GetKVStore(keyIndex).Set(k, v)
+		store.Commit()
+		commitID := store.Commit()
+
+		key3, key4 := sdk.NewKVStoreKey("store3"), sdk.NewKVStoreKey("store4")
+		keyIndex2 := sdk.NewKVStoreKey("index2")
+		newStore := func(height int64) *rootMultiStore {
+			store := NewCommitMultiStore(db)
+			store.MountStoreWithDB(key3, sdk.StoreTypeIAVL, db3)
+			store.MountStoreWithDB(key4, sdk.StoreTypeIAVL, db2)
+			store.MountStoreWithDB(keyIndex2, sdk.StoreTypeDB, nil)
+			if height > 0 {
+				store.SetStoreUpgrades(height, upgrades)
+			}
+			return store
+		}
+
+		// the new layout doesn't load without the upgrades,
+		// nor with upgrades at another height
+		assert.NotNil(t, newStore(0).LoadLatestVersion())
+		assert.NotNil(t, newStore(4).LoadLatestVersion())
+
+		store = newStore(3)
+		err = store.LoadLatestVersion()
+		assert.Nil(t, err)
+		assert.Equal(t, commitID, store.LastCommitID())
+		assert.Equal(t, v, store.GetKVStore(key4).Get(k))
+		assert.Equal(t, v, store.GetKVStore(keyIndex2).Get(k))
+		assert.Nil(t, store.GetKVStore(key3).Get(k))
+		store.GetKVStore(key3).Set(k, v)
+		commitID = store.Commit()
+		assert.Equal(t, int64(3), commitID.Version)
+
+		cInfo, err := getCommitInfo(db, 3)
+		assert.Nil(t, err)
+		names := []string{}
+		for _, storeInfo := range cInfo.StoreInfos {
+			names = append(names, storeInfo.Name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, []string{"index2", "store3", "store4"}, names)
+
+		// reload after the upgrades, and look at the past
+		store = newStore(3)
+		err = store.LoadLatestVersion()
+		assert.Nil(t, err)
+		assert.Equal(t, commitID, store.LastCommitID())
+		assert.Equal(t, v, store.GetKVStore(key3).Get(k))
+		assert.Equal(t, v, store.GetKVStore(keyIndex2).Get(k))
+		// the past of the db store isn't kept
+		_, err = store.CacheMultiStoreWithVersion(2)
+		assert.NotNil(t, err)
+
+		// versions before the upgrades can't be loaded anymore
+		assert.NotNil(t, newStore(3).LoadVersion(1))
+		return commitID
+	}
+
+	// every node transitions to the same commit
+	assert.Equal(t, runUpgrade(), runUpgrade())
+}
+
 func TestParsePath(t *testing.T) {
 	_, _, err := parsePath("foo")
 	assert.Error(t, err)
@@ -146,12 +507,73 @@ func TestMultiStoreQuery(t *testing.T) {
 	qres = multi.Query(query)
 	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
 	assert.Nil(t, qres.Value)
+	err = VerifyMultiStoreProof(qres.Proof, cid.Hash, "store2", k, nil)
+	assert.Nil(t, err)
 
 	// store2 data
 	query.Data = k2
 	qres = multi.Query(query)
 	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
 	assert.Equal(t, v2, qres.Value)
+	err = VerifyMultiStoreProof(qres.Proof, cid.Hash, "store2", k2, v2)
+	assert.Nil(t, err)
+
+	// the proof doesn't hold for another value, store or commit
+	err = VerifyMultiStoreProof(qres.Proof, cid.Hash, "store2", k2, v)
+	assert.NotNil(t, err)
+	err = VerifyMultiStoreProof(qres.Proof, cid.Hash, "store1", k2, v2)
+	assert.NotNil(t, err)
+	err = VerifyMultiStoreProof(qres.Proof, []byte("garbage"), "store2", k2, v2)
+	assert.NotNil(t, err)
+
+	// store2 subspace
+	query.Path = "/store2/subspace"
+	query.Data = []byte("wa")
+	qres = multi.Query(query)
+	assert.Equal(t, uint32(sdk.CodeOK), qres.Code)
+	var kvs []cmn.KVPair
+	err = cdc.UnmarshalBinary(qres.Value, &kvs)
+	assert.Nil(t, err)
+	assert.Equal(t, []cmn.KVPair{{k2, v2}}, kvs)
+	err = VerifyMultiStoreRangeProof(qres.Proof, cid.Hash, "store2", query.Data, kvs)
+	assert.Nil(t, err)
+	err = VerifyMultiStoreRangeProof(qres.Proof, cid.Hash, "store2", query.Data, nil)
+	assert.NotNil(t, err)
+}
+
+func TestCacheMultiStoreWithVersion(t *testing.T) {
+	db := dbm.NewMemDB()
+	multi := NewCommitMultiStore(db)
+	key := sdk.NewKVStoreKey("store1")
+	multi.MountStoreWithDB(key, sdk.StoreTypeIAVL, dbm.NewMemDB())
+	err := multi.LoadLatestVersion()
+	assert.Nil(t, err)
+
+	k, v1, v2 := []byte("wind"), []byte("blows"), []byte("howls")
+
+	// commit two versions of the same key
+	multi.GetKVStore(key).Set(k, v1)
+	multi.Commit()
+	multi.GetKVStore(key).Set(k, v2)
+	multi.Commit()
+
+	// each version sees its own value
+	cms1, err := multi.CacheMultiStoreWithVersion(1)
+	assert.Nil(t, err)
+	assert.Equal(t, v1, cms1.GetKVStore(key).Get(k))
+	cms2, err := multi.CacheMultiStoreWithVersion(2)
+	assert.Nil(t, err)
+	assert.Equal(t, v2, cms2.GetKVStore(key).Get(k))
+
+	// writing to an old version doesn't touch the latest
+	cms1.GetKVStore(key).Set(k, []byte("garbage"))
+	assert.Equal(t, v2, multi.GetKVStore(key).Get(k))
+
+	// uncommitted versions can't be loaded
+	_, err = multi.CacheMultiStoreWithVersion(3)
+	assert.NotNil(t, err)
+	_, err = multi.CacheMultiStoreWithVersion(-1)
+	assert.NotNil(t, err)
 }
 
 //-----------------------------------------------------------------------
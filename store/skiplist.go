@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"math/rand"
+)
+
+const (
+	skipListMaxLevel = 24
+	skipListP        = 4 // 1 in skipListP nodes is promoted to the next level
+)
+
+// skipList is a sorted set of keys, kept in order as they are inserted.
+// The bottom level is doubly linked, for iteration in both directions.
+// It is not safe for concurrent use.
+type skipList struct {
+	head  *skipNode // sentinel, holds no key
+	tail  *skipNode // the last node, or nil if empty
+	level int
+	len   int
+	rnd   *rand.Rand
+}
+
+type skipNode struct {
+	key  []byte
+	next []*skipNode
+	prev *skipNode // nil for the first node
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level: 1,
+		// The layout doesn't need to be unpredictable, only balanced.
+		rnd: rand.New(rand.NewSource(1)),
+	}
+}
+
+// Len returns the number of keys.
+func (sl *skipList) Len() int {
+	return sl.len
+}
+
+// Insert adds the key, unless it is already in the list.
+func (sl *skipList) Insert(key []byte) {
+	var update [skipListMaxLevel]*skipNode
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && bytes.Compare(node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+	if next := node.next[0]; next != nil && bytes.Equal(next.key, key) {
+		return
+	}
+
+	level := sl.randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.head
+		}
+		sl.level = level
+	}
+	newNode := &skipNode{
+		key:  cp(key),
+		next: make([]*skipNode, level),
+	}
+	for i := 0; i < level; i++ {
+		newNode.next[i] = update[i].next[i]
+		update[i].next[i] = newNode
+	}
+	if update[0] != sl.head {
+		newNode.prev = update[0]
+	}
+	if newNode.next[0] != nil {
+		newNode.next[0].prev = newNode
+	} else {
+		sl.tail = newNode
+	}
+	sl.len++
+}
+
+// First returns the first node, or nil if empty.
+func (sl *skipList) First() *skipNode {
+	return sl.head.next[0]
+}
+
+// Last returns the last node, or nil if empty.
+func (sl *skipList) Last() *skipNode {
+	return sl.tail
+}
+
+// Seek returns the first node with a key >= key, or nil if there is none.
+func (sl *skipList) Seek(key []byte) *skipNode {
+	node := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && bytes.Compare(node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+	}
+	return node.next[0]
+}
+
+func (sl *skipList) randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && sl.rnd.Intn(skipListP) == 0 {
+		level++
+	}
+	return level
+}
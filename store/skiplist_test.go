@@ -0,0 +1,54 @@
+package store
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	cmn "github.com/tendermint/tmlibs/common"
+)
+
+func TestSkipList(t *testing.T) {
+	sl := newSkipList()
+	assert.Nil(t, sl.First())
+	assert.Nil(t, sl.Last())
+	assert.Nil(t, sl.Seek(bz("key")))
+
+	// insert random keys, some of them twice
+	keys := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key := cmn.Fmt("key%0.4d", randInt(500))
+		keys[key] = true
+		sl.Insert(bz(key))
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	assert.Equal(t, len(sorted), sl.Len())
+
+	// both directions are in order
+	i := 0
+	for node := sl.First(); node != nil; node = node.next[0] {
+		assert.Equal(t, sorted[i], string(node.key))
+		i++
+	}
+	assert.Equal(t, len(sorted), i)
+	for node := sl.Last(); node != nil; node = node.prev {
+		i--
+		assert.Equal(t, sorted[i], string(node.key))
+	}
+	assert.Equal(t, 0, i)
+
+	// seek finds the first key >= the given one
+	for _, key := range []string{"key", "key0250", "key02505", "key9"} {
+		i := sort.SearchStrings(sorted, key)
+		node := sl.Seek(bz(key))
+		if i == len(sorted) {
+			assert.Nil(t, node)
+		} else {
+			assert.Equal(t, sorted[i], string(node.key))
+		}
+	}
+}
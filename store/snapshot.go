@@ -0,0 +1,457 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/tendermint/iavl"
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// A snapshot holds the latest version of the stores of a rootMultiStore.
+// For an IAVL store, it holds the raw nodes of its latest tree, so that
+// the tree is rebuilt node for node, with the same hash.  For a db store,
+// it holds its pairs.  Each store is split into chunks of go-wire encoded
+// []cmn.KVPair, hashed with SHA256 in the manifest.
+const (
+	snapshotManifestFile = "manifest.json"
+	snapshotChunkFileFmt = "%s.%06d" // <store name>.<chunk index>
+
+	// DefaultSnapshotChunkSize is the approximate size in bytes of the
+	// pairs in a chunk.
+	DefaultSnapshotChunkSize = 16 << 20
+)
+
+type snapshotManifest struct {
+	Version    int64           `json:"version"`
+	CommitHash []byte          `json:"commit_hash"`
+	CommitInfo commitInfo      `json:"commit_info"`
+	Chunks     []snapshotChunk `json:"chunks"`
+}
+
+type snapshotChunk struct {
+	Store string `json:"store"`
+	File  string `json:"file"`
+	Hash  []byte `json:"hash"` // SHA256 of the file
+}
+
+// ExportSnapshot writes a snapshot of the latest version into dir,
+// which must exist.  The stores must not be written to meanwhile.
+// Returns the CommitID of the snapshot.
+func (rs *rootMultiStore) ExportSnapshot(dir string, chunkSize int) (CommitID, error) {
+	version := rs.lastCommitID.Version
+	if version == 0 {
+		return CommitID{}, fmt.Errorf("Nothing to export before the first commit")
+	}
+	cInfo, err := getCommitInfo(rs.db, version)
+	if err != nil {
+		return CommitID{}, err
+	}
+
+	manifest := snapshotManifest{
+		Version:    version,
+		CommitHash: cInfo.Hash(),
+		CommitInfo: cInfo,
+	}
+	for _, storeInfo := range sortedStoreInfos(cInfo) {
+		key, err := rs.snapshotStoreKey(storeInfo.Name, version)
+		if err != nil {
+			return CommitID{}, err
+		}
+		var chunks []snapshotChunk
+		switch params := rs.storesParams[key]; params.typ {
+		case sdk.StoreTypeIAVL:
+			tree := rs.stores[key].(*iavlStore).tree
+			chunks, err = exportIAVLSnapshot(dir, storeInfo, tree, params.db, chunkSize)
+		case sdk.StoreTypeDB:
+			iter := rs.dbStoreData(key).Iterator(nil, nil)
+			chunks, err = exportSnapshotChunks(dir, storeInfo.Name, iter, chunkSize)
+			iter.Close()
+		}
+		if err != nil {
+			return CommitID{}, err
+		}
+		manifest.Chunks = append(manifest.Chunks, chunks...)
+	}
+
+	bz, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return CommitID{}, err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, snapshotManifestFile), bz, 0644)
+	if err != nil {
+		return CommitID{}, err
+	}
+	return cInfo.CommitID(), nil
+}
+
+// ImportSnapshot rebuilds the stores from the snapshot in dir, and loads
+// its version.  The stores must be mounted as in the exporting
+// rootMultiStore, on empty databases, and not loaded yet.  On error, the
+// databases must be wiped before importing again.
+// Every chunk is checked against its hash in the manifest, and the nodes
+// of each IAVL tree against the hash of its store in the commitInfo.  The
+// caller should check the returned CommitID against the AppHash of the
+// chain.  The pairs of db stores aren't part of the AppHash, so they are
+// only checked against the manifest.
+func (rs *rootMultiStore) ImportSnapshot(dir string) (CommitID, error) {
+	if getLatestVersion(rs.db) != 0 {
+		return CommitID{}, fmt.Errorf("Cannot import a snapshot over existing versions")
+	}
+	bz, err := ioutil.ReadFile(filepath.Join(dir, snapshotManifestFile))
+	if err != nil {
+		return CommitID{}, err
+	}
+	var manifest snapshotManifest
+	err = json.Unmarshal(bz, &manifest)
+	if err != nil {
+		return CommitID{}, fmt.Errorf("Failed to decode snapshot manifest: %v", err)
+	}
+	expected := manifest.CommitInfo.CommitID()
+	if expected.Version != manifest.Version || !bytes.Equal(expected.Hash, manifest.CommitHash) {
+		return CommitID{}, fmt.Errorf("Snapshot manifest commit %v doesn't match its commitInfo %v",
+			CommitID{manifest.Version, manifest.CommitHash}, expected)
+	}
+
+	// The chunks of each store, whose files are named after it in order.
+	chunks := make(map[string][]snapshotChunk)
+	for _, chunk := range manifest.Chunks {
+		file := fmt.Sprintf(snapshotChunkFileFmt, chunk.Store, len(chunks[chunk.Store]))
+		if chunk.File != file || filepath.Base(file) != file {
+			return CommitID{}, fmt.Errorf("Invalid snapshot chunk file %q", chunk.File)
+		}
+		chunks[chunk.Store] = append(chunks[chunk.Store], chunk)
+	}
+	committed := make(map[string]bool)
+	for _, storeInfo := range manifest.CommitInfo.StoreInfos {
+		committed[storeInfo.Name] = true
+	}
+	for name := range chunks {
+		if !committed[name] {
+			return CommitID{}, fmt.Errorf("Snapshot has chunks of store %v, which isn't committed", name)
+		}
+	}
+
+	for _, storeInfo := range sortedStoreInfos(manifest.CommitInfo) {
+		key, err := rs.snapshotStoreKey(storeInfo.Name, manifest.Version)
+		if err != nil {
+			return CommitID{}, err
+		}
+		storeChunks := chunks[storeInfo.Name]
+		switch params := rs.storesParams[key]; params.typ {
+		case sdk.StoreTypeIAVL:
+			err = importIAVLSnapshot(dir, storeInfo, storeChunks, params.db)
+		case sdk.StoreTypeDB:
+			err = importDBSnapshot(dir, storeInfo, storeChunks, rs.dbStoreData(key))
+		}
+		if err != nil {
+			return CommitID{}, err
+		}
+	}
+
+	// Point to the version, now that the stores are in place.
+	batch := rs.db.NewBatch()
+	setCommitInfo(batch, manifest.Version, manifest.CommitInfo)
+	setLatestVersion(batch, manifest.Version)
+	batch.Write()
+
+	err = rs.LoadVersion(manifest.Version)
+	if err != nil {
+		return CommitID{}, err
+	}
+
+	// Hash the loaded stores, rather than the commitInfo read back.
+	loaded := commitInfo{Version: manifest.Version}
+	for key, store := range rs.stores {
+		if store.GetStoreType() == sdk.StoreTypeTransient {
+			continue
+		}
+		si := storeInfo{}
+		si.Name = key.Name()
+		si.Core.CommitID = store.LastCommitID()
+		loaded.StoreInfos = append(loaded.StoreInfos, si)
+	}
+	if !bytes.Equal(loaded.Hash(), expected.Hash) {
+		return CommitID{}, fmt.Errorf("Imported commit %v doesn't match the snapshot commit %v",
+			loaded.CommitID(), expected)
+	}
+	return rs.lastCommitID, nil
+}
+
+// Returns the key of a committed store which can be snapshotted.
+func (rs *rootMultiStore) snapshotStoreKey(name string, ver int64) (StoreKey, error) {
+	key, err := rs.nameToKey(name, ver)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("Cannot snapshot store %v, it is deleted by the upgrades", name)
+	}
+	if rs.storesParams[key].typ == sdk.StoreTypeMulti {
+		return nil, fmt.Errorf("Cannot snapshot nested multistore %v", name)
+	}
+	return key, nil
+}
+
+// Returns the committed pairs of a db store, without its buffered writes.
+func (rs *rootMultiStore) dbStoreData(key StoreKey) KVStore {
+	prefix := []byte(fmt.Sprintf(dbStoreKeyFmt, key.Name()))
+	return NewPrefixStore(dbStoreAdapter{rs.db}, prefix)
+}
+
+//----------------------------------------
+
+// Writes the nodes of the tree of an IAVL store at its committed version,
+// read from its db by hash.  Every node but the root is the sibling of a
+// node on the path to some leaf, so the root and the siblings in the
+// proofs of all the leaves are all the nodes of the tree.
+func exportIAVLSnapshot(dir string, storeInfo storeInfo, tree *iavl.VersionedTree, db dbm.DB, chunkSize int) ([]snapshotChunk, error) {
+	id := storeInfo.Core.CommitID
+	if !bytes.Equal(tree.Hash(), id.Hash) {
+		return nil, fmt.Errorf("IAVL store %v was written after version %v", storeInfo.Name, id.Version)
+	}
+	cw := newSnapshotChunkWriter(dir, storeInfo.Name, chunkSize)
+	rootKey := findIAVLRootKey(db, id.Version)
+	if rootKey == nil {
+		return nil, fmt.Errorf("IAVL store %v has no root for version %v", storeInfo.Name, id.Version)
+	}
+	err := cw.add(rootKey, db.Get(rootKey))
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := [][]byte{}
+	seen := make(map[string]bool)
+	addNode := func(hash []byte) {
+		if len(hash) > 0 && !seen[string(hash)] {
+			seen[string(hash)] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	addNode(id.Hash)
+	iter := newIAVLIterator(tree.Tree(), nil, nil, true)
+	for ; iter.Valid(); iter.Next() {
+		key := iter.Key()
+		_, proof, err := tree.GetVersionedWithProof(key, id.Version)
+		if err != nil {
+			iter.Close()
+			return nil, fmt.Errorf("Failed to prove key %X of IAVL store %v: %v", key, storeInfo.Name, err)
+		}
+		exists, ok := proof.(*iavl.KeyExistsProof)
+		if !ok {
+			iter.Close()
+			return nil, fmt.Errorf("Key %X of IAVL store %v isn't in version %v", key, storeInfo.Name, id.Version)
+		}
+		for _, node := range exists.InnerNodes {
+			addNode(node.Left)
+			addNode(node.Right)
+		}
+	}
+	iter.Close()
+
+	for _, hash := range hashes {
+		key := iavlNodeKey(hash)
+		value := db.Get(key)
+		if value == nil {
+			return nil, fmt.Errorf("IAVL store %v is missing node %X", storeInfo.Name, hash)
+		}
+		err = cw.add(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cw.finish()
+}
+
+// Returns the key of the root record of an IAVL version in its db, or nil.
+func findIAVLRootKey(db dbm.DB, ver int64) []byte {
+	roots := NewPrefixStore(dbStoreAdapter{db}, []byte(iavlRootPrefix))
+	iter := roots.Iterator(nil, nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		version, err := strconv.ParseInt(string(iter.Key()), 10, 64)
+		if err == nil && version == ver {
+			return append([]byte(iavlRootPrefix), iter.Key()...)
+		}
+	}
+	return nil
+}
+
+// Writes the nodes of an IAVL tree into its empty db, and checks that they
+// are exactly the nodes of the tree of the store's CommitID.  Every leaf
+// is proven against the hash, which proves all the inner nodes too, and a
+// tree of n leaves has n-1 inner nodes and a root record, so no other
+// pairs are left.
+func importIAVLSnapshot(dir string, storeInfo storeInfo, chunks []snapshotChunk, db dbm.DB) error {
+	if !isEmpty(dbStoreAdapter{db}) {
+		return fmt.Errorf("Cannot import IAVL store %v, its db isn't empty", storeInfo.Name)
+	}
+	pairs := 0
+	for _, chunk := range chunks {
+		kvs, err := readSnapshotChunk(dir, chunk)
+		if err != nil {
+			return err
+		}
+		batch := db.NewBatch()
+		for _, kv := range kvs {
+			batch.Set(kv.Key, kv.Value)
+		}
+		batch.Write()
+		pairs += len(kvs)
+	}
+
+	id := storeInfo.Core.CommitID
+	tree := iavl.NewVersionedTree(db, defaultIAVLCacheSize)
+	err := tree.LoadVersion(id.Version)
+	if err != nil {
+		return fmt.Errorf("Failed to load IAVL store %v: %v", storeInfo.Name, err)
+	}
+	if !bytes.Equal(tree.Hash(), id.Hash) {
+		return fmt.Errorf("IAVL store %v has hash %X, expected %X", storeInfo.Name, tree.Hash(), id.Hash)
+	}
+	leaves := 0
+	iter := newIAVLIterator(tree.Tree(), nil, nil, true)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		key, value := iter.Key(), iter.Value()
+		_, proof, err := tree.GetVersionedWithProof(key, id.Version)
+		if err == nil {
+			err = proof.Verify(key, value, id.Hash)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to prove key %X of IAVL store %v: %v", key, storeInfo.Name, err)
+		}
+		leaves++
+	}
+	if (leaves == 0 && pairs > 1) || (leaves > 0 && pairs != 2*leaves) {
+		return fmt.Errorf("IAVL store %v has %v pairs, which aren't all the nodes of its %v leaves",
+			storeInfo.Name, pairs, leaves)
+	}
+	return nil
+}
+
+// Writes the pairs of a db store into its empty data.  They aren't
+// merklized, so they can only be checked against the chunk hashes.
+func importDBSnapshot(dir string, storeInfo storeInfo, chunks []snapshotChunk, data KVStore) error {
+	if !isEmpty(data) {
+		return fmt.Errorf("Cannot import db store %v, it isn't empty", storeInfo.Name)
+	}
+	for _, chunk := range chunks {
+		kvs, err := readSnapshotChunk(dir, chunk)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			data.Set(kv.Key, kv.Value)
+		}
+	}
+	return nil
+}
+
+// Writes the pairs of the iterator into chunk files.
+func exportSnapshotChunks(dir string, name string, iter Iterator, chunkSize int) ([]snapshotChunk, error) {
+	cw := newSnapshotChunkWriter(dir, name, chunkSize)
+	for ; iter.Valid(); iter.Next() {
+		err := cw.add(cp(iter.Key()), cp(iter.Value()))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cw.finish()
+}
+
+// snapshotChunkWriter writes the pairs of a store into chunk files of
+// about chunkSize bytes.
+type snapshotChunkWriter struct {
+	dir, name string
+	chunkSize int
+	chunks    []snapshotChunk
+	kvs       []cmn.KVPair
+	size      int
+}
+
+func newSnapshotChunkWriter(dir, name string, chunkSize int) *snapshotChunkWriter {
+	return &snapshotChunkWriter{
+		dir:       dir,
+		name:      name,
+		chunkSize: chunkSize,
+	}
+}
+
+// Adds a pair, and writes the chunk once it is full.
+func (cw *snapshotChunkWriter) add(key, value []byte) error {
+	cw.kvs = append(cw.kvs, cmn.KVPair{Key: key, Value: value})
+	cw.size += len(key) + len(value)
+	if cw.size >= cw.chunkSize {
+		return cw.flush()
+	}
+	return nil
+}
+
+// Writes the last chunk, and returns all of them.
+func (cw *snapshotChunkWriter) finish() ([]snapshotChunk, error) {
+	if len(cw.kvs) > 0 {
+		if err := cw.flush(); err != nil {
+			return nil, err
+		}
+	}
+	return cw.chunks, nil
+}
+
+func (cw *snapshotChunkWriter) flush() error {
+	bz, err := cdc.MarshalBinary(cw.kvs)
+	if err != nil {
+		return err
+	}
+	file := fmt.Sprintf(snapshotChunkFileFmt, cw.name, len(cw.chunks))
+	err = ioutil.WriteFile(filepath.Join(cw.dir, file), bz, 0644)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(bz)
+	cw.chunks = append(cw.chunks, snapshotChunk{cw.name, file, hash[:]})
+	cw.kvs, cw.size = nil, 0
+	return nil
+}
+
+// Checks the hash of a chunk file, and decodes its pairs.
+func readSnapshotChunk(dir string, chunk snapshotChunk) ([]cmn.KVPair, error) {
+	bz, err := ioutil.ReadFile(filepath.Join(dir, chunk.File))
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(bz)
+	if !bytes.Equal(hash[:], chunk.Hash) {
+		return nil, fmt.Errorf("Snapshot chunk %v has hash %X, expected %X", chunk.File, hash[:], chunk.Hash)
+	}
+	var kvs []cmn.KVPair
+	err = cdc.UnmarshalBinary(bz, &kvs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode snapshot chunk %v: %v", chunk.File, err)
+	}
+	return kvs, nil
+}
+
+func sortedStoreInfos(cInfo commitInfo) []storeInfo {
+	storeInfos := make([]storeInfo, len(cInfo.StoreInfos))
+	copy(storeInfos, cInfo.StoreInfos)
+	sort.Slice(storeInfos, func(i, j int) bool {
+		return storeInfos[i].Name < storeInfos[j].Name
+	})
+	return storeInfos
+}
+
+func isEmpty(store KVStore) bool {
+	iter := store.Iterator(nil, nil)
+	defer iter.Close()
+	return !iter.Valid()
+}
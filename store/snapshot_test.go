@@ -0,0 +1,226 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/tmlibs/common"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+var (
+	snapshotKey1 = sdk.NewKVStoreKey("store1")
+	snapshotKey2 = sdk.NewKVStoreKey("store2")
+	snapshotKey3 = sdk.NewKVStoreKey("index")
+)
+
+// Mounts IAVL stores with their own dbs, and a db store on the root db.
+func newSnapshotMultiStore() *rootMultiStore {
+	store := NewCommitMultiStore(dbm.NewMemDB())
+	store.MountStoreWithDB(snapshotKey1, sdk.StoreTypeIAVL, dbm.NewMemDB())
+	store.MountStoreWithDB(snapshotKey2, sdk.StoreTypeIAVL, dbm.NewMemDB())
+	store.MountStoreWithDB(snapshotKey3, sdk.StoreTypeDB, nil)
+	return store
+}
+
+func TestSnapshotExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// commit a few versions
+	store := newSnapshotMultiStore()
+	err = store.LoadLatestVersion()
+	require.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		for _, key := range []StoreKey{snapshotKey1, snapshotKey2, snapshotKey3} {
+			kv := store.GetKVStore(key)
+			kv.Set(keyFmt(i), valFmt(i))
+			kv.Delete(keyFmt(i - 2))
+		}
+		store.Commit()
+	}
+
+	// small chunks, so there are several per db
+	commitID, err := store.ExportSnapshot(dir, 100)
+	require.Nil(t, err)
+	assert.Equal(t, store.LastCommitID(), commitID)
+
+	// the snapshot rebuilds the same stores
+	store2 := newSnapshotMultiStore()
+	commitID2, err := store2.ImportSnapshot(dir)
+	require.Nil(t, err)
+	assert.Equal(t, commitID, commitID2)
+	assert.Equal(t, commitID, store2.LastCommitID())
+	for _, key := range []StoreKey{snapshotKey1, snapshotKey2, snapshotKey3} {
+		assert.Equal(t, valFmt(4), store2.GetKVStore(key).Get(keyFmt(4)))
+		assert.Nil(t, store2.GetKVStore(key).Get(keyFmt(2)))
+	}
+
+	// and both commit the same next version
+	for _, st := range []*rootMultiStore{store, store2} {
+		st.GetKVStore(snapshotKey1).Set(keyFmt(5), valFmt(5))
+	}
+	assert.Equal(t, store.Commit(), store2.Commit())
+
+	// it can't be imported over existing versions
+	_, err = store2.ImportSnapshot(dir)
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotRootDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the IAVL stores are kept in the root db, as by BaseApp
+	newStore := func(db dbm.DB) *rootMultiStore {
+		store := NewCommitMultiStore(db)
+		store.MountStoreWithDB(snapshotKey1, sdk.StoreTypeIAVL, nil)
+		store.MountStoreWithDB(snapshotKey2, sdk.StoreTypeIAVL, nil)
+		store.MountStoreWithDB(snapshotKey3, sdk.StoreTypeDB, nil)
+		return store
+	}
+	store := newStore(dbm.NewMemDB())
+	err = store.LoadLatestVersion()
+	require.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		for _, key := range []StoreKey{snapshotKey1, snapshotKey2, snapshotKey3} {
+			kv := store.GetKVStore(key)
+			kv.Set(keyFmt(i), valFmt(i))
+			kv.Delete(keyFmt(i - 2))
+		}
+		store.Commit()
+	}
+
+	commitID, err := store.ExportSnapshot(dir, 100)
+	require.Nil(t, err)
+
+	store2 := newStore(dbm.NewMemDB())
+	commitID2, err := store2.ImportSnapshot(dir)
+	require.Nil(t, err)
+	assert.Equal(t, commitID, commitID2)
+	for _, key := range []StoreKey{snapshotKey1, snapshotKey2, snapshotKey3} {
+		assert.Equal(t, valFmt(4), store2.GetKVStore(key).Get(keyFmt(4)))
+		assert.Nil(t, store2.GetKVStore(key).Get(keyFmt(2)))
+	}
+}
+
+func TestSnapshotCorruptChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newSnapshotMultiStore()
+	err = store.LoadLatestVersion()
+	require.Nil(t, err)
+	store.GetKVStore(snapshotKey1).Set(keyFmt(1), valFmt(1))
+	store.Commit()
+	_, err = store.ExportSnapshot(dir, DefaultSnapshotChunkSize)
+	require.Nil(t, err)
+
+	// tamper with a chunk
+	file := filepath.Join(dir, "store1.000000")
+	bz, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+	bz[len(bz)-1] ^= 0xff
+	err = ioutil.WriteFile(file, bz, 0644)
+	require.Nil(t, err)
+
+	_, err = newSnapshotMultiStore().ImportSnapshot(dir)
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotLatestTreeOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newSnapshotMultiStore()
+	err = store.LoadLatestVersion()
+	require.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		kv := store.GetKVStore(snapshotKey1)
+		kv.Set(keyFmt(i), valFmt(i))
+		kv.Delete(keyFmt(i - 2))
+		store.Commit()
+	}
+	_, err = store.ExportSnapshot(dir, DefaultSnapshotChunkSize)
+	require.Nil(t, err)
+
+	// two leaves, their parent, and the root record
+	manifest := readSnapshotManifest(t, dir)
+	require.Equal(t, "store1", manifest.Chunks[0].Store)
+	kvs, err := readSnapshotChunk(dir, manifest.Chunks[0])
+	require.Nil(t, err)
+	assert.Equal(t, 4, len(kvs))
+
+	// a chunk with an extra pair is rejected, even with the right hash
+	kvs = append(kvs, cmn.KVPair{Key: bz("junk"), Value: bz("junk")})
+	chunk, err := cdc.MarshalBinary(kvs)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, manifest.Chunks[0].File), chunk, 0644)
+	require.Nil(t, err)
+	hash := sha256.Sum256(chunk)
+	manifest.Chunks[0].Hash = hash[:]
+	writeSnapshotManifest(t, dir, manifest)
+	_, err = newSnapshotMultiStore().ImportSnapshot(dir)
+	assert.NotNil(t, err)
+}
+
+func TestSnapshotChunkFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := newSnapshotMultiStore()
+	err = store.LoadLatestVersion()
+	require.Nil(t, err)
+	store.GetKVStore(snapshotKey1).Set(keyFmt(1), valFmt(1))
+	store.Commit()
+	_, err = store.ExportSnapshot(dir, DefaultSnapshotChunkSize)
+	require.Nil(t, err)
+	manifest := readSnapshotManifest(t, dir)
+
+	// chunk files outside of the snapshot are rejected
+	bad := manifest
+	bad.Chunks = []snapshotChunk{manifest.Chunks[0]}
+	bad.Chunks[0].File = "../" + manifest.Chunks[0].File
+	writeSnapshotManifest(t, dir, bad)
+	_, err = newSnapshotMultiStore().ImportSnapshot(dir)
+	assert.NotNil(t, err)
+
+	// and so are the chunks of unknown stores
+	bad.Chunks[0].Store, bad.Chunks[0].File = "store9", "store9.000000"
+	writeSnapshotManifest(t, dir, bad)
+	_, err = newSnapshotMultiStore().ImportSnapshot(dir)
+	assert.NotNil(t, err)
+
+	writeSnapshotManifest(t, dir, manifest)
+	_, err = newSnapshotMultiStore().ImportSnapshot(dir)
+	assert.Nil(t, err)
+}
+
+func readSnapshotManifest(t *testing.T, dir string) snapshotManifest {
+	bz, err := ioutil.ReadFile(filepath.Join(dir, snapshotManifestFile))
+	require.Nil(t, err)
+	var manifest snapshotManifest
+	err = json.Unmarshal(bz, &manifest)
+	require.Nil(t, err)
+	return manifest
+}
+
+func writeSnapshotManifest(t *testing.T, dir string, manifest snapshotManifest) {
+	bz, err := json.Marshal(manifest)
+	require.Nil(t, err)
+	err = ioutil.WriteFile(filepath.Join(dir, snapshotManifestFile), bz, 0644)
+	require.Nil(t, err)
+}
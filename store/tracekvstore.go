@@ -0,0 +1,210 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/tendermint/tmlibs/log"
+)
+
+type traceOp string
+
+const (
+	traceOpRead      traceOp = "read"
+	traceOpHas       traceOp = "has"
+	traceOpWrite     traceOp = "write"
+	traceOpDelete    traceOp = "delete"
+	traceOpIterKey   traceOp = "iterKey"
+	traceOpIterValue traceOp = "iterValue"
+)
+
+// traceOperation is written as a JSON line for every operation.
+// Keys and values are base64 encoded.
+type traceOperation struct {
+	Store     string       `json:"store"`
+	Operation traceOp      `json:"operation"`
+	Key       []byte       `json:"key"`
+	Value     []byte       `json:"value"`
+	Metadata  TraceContext `json:"metadata"`
+}
+
+// traceKVStore writes every operation on an underlying KVStore
+// to an io.Writer, along with the current TraceContext, e.g. the
+// block height and the index of the tx.
+// Implements KVStore.
+type traceKVStore struct {
+	parent  KVStore
+	name    string
+	writer  *traceWriter
+	context TraceContext
+}
+
+var _ KVStore = &traceKVStore{}
+
+// NewTraceKVStore returns a KVStore tracing the operations on parent,
+// under the store name, along with context.  If writing the trace
+// fails, the error is logged to logger and tracing stops.
+func NewTraceKVStore(parent KVStore, name string, writer io.Writer, logger log.Logger, context TraceContext) *traceKVStore {
+	return &traceKVStore{
+		parent:  parent,
+		name:    name,
+		writer:  newTraceWriter(writer, logger),
+		context: context,
+	}
+}
+
+// Implements Store.
+func (tkv *traceKVStore) GetStoreType() StoreType {
+	return tkv.parent.GetStoreType()
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) Get(key []byte) []byte {
+	value := tkv.parent.Get(key)
+	tkv.trace(traceOpRead, key, value)
+	return value
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) Has(key []byte) bool {
+	tkv.trace(traceOpHas, key, nil)
+	return tkv.parent.Has(key)
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) Set(key []byte, value []byte) {
+	tkv.trace(traceOpWrite, key, value)
+	tkv.parent.Set(key, value)
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) Delete(key []byte) {
+	tkv.trace(traceOpDelete, key, nil)
+	tkv.parent.Delete(key)
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) Iterator(start, end []byte) Iterator {
+	return &traceIterator{tkv, tkv.parent.Iterator(start, end)}
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) ReverseIterator(start, end []byte) Iterator {
+	return &traceIterator{tkv, tkv.parent.ReverseIterator(start, end)}
+}
+
+// Implements KVStore.
+func (tkv *traceKVStore) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, tkv)
+}
+
+// Implements Store.
+func (tkv *traceKVStore) CacheWrap() CacheWrap {
+	return NewCacheKVStore(tkv)
+}
+
+// Writes the operation as a JSON line.
+// The errors are handled by the traceWriter, so that a broken trace
+// can't halt the node, e.g. in the middle of a Commit.
+func (tkv *traceKVStore) trace(op traceOp, key, value []byte) {
+	bz, err := json.Marshal(traceOperation{
+		Store:     tkv.name,
+		Operation: op,
+		Key:       key,
+		Value:     value,
+		Metadata:  tkv.context,
+	})
+	if err != nil {
+		tkv.writer.logger.Error("Failed to encode the store trace", "err", err)
+		return
+	}
+	tkv.writer.Write(append(bz, '\n')) // Does not error
+}
+
+//----------------------------------------
+
+// traceWriter wraps the io.Writer of the trace.  After the first error,
+// which is logged, it drops the rest of the trace.  It is shared by all
+// the stores traced to the same writer, so that they all stop together.
+// Implements io.Writer.
+type traceWriter struct {
+	mtx      sync.Mutex
+	writer   io.Writer
+	logger   log.Logger
+	disabled bool
+}
+
+// Returns w itself if it is already a traceWriter, and nil for nil.
+// A nil logger drops the errors.
+func newTraceWriter(w io.Writer, logger log.Logger) *traceWriter {
+	if w == nil {
+		return nil
+	}
+	if tw, ok := w.(*traceWriter); ok {
+		return tw
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &traceWriter{writer: w, logger: logger}
+}
+
+// Implements io.Writer.  Never returns an error.
+func (tw *traceWriter) Write(bz []byte) (int, error) {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+
+	if tw.disabled {
+		return len(bz), nil
+	}
+	_, err := tw.writer.Write(bz)
+	if err != nil {
+		tw.logger.Error("Failed to write the store trace, tracing is disabled", "err", err)
+		tw.disabled = true
+	}
+	return len(bz), nil
+}
+
+//----------------------------------------
+
+// traceIterator traces the keys and values it yields.
+// Implements Iterator.
+type traceIterator struct {
+	tkv    *traceKVStore
+	parent Iterator
+}
+
+// Implements Iterator.
+func (ti *traceIterator) Domain() (start []byte, end []byte) {
+	return ti.parent.Domain()
+}
+
+// Implements Iterator.
+func (ti *traceIterator) Valid() bool {
+	return ti.parent.Valid()
+}
+
+// Implements Iterator.
+func (ti *traceIterator) Next() {
+	ti.parent.Next()
+}
+
+// Implements Iterator.
+func (ti *traceIterator) Key() []byte {
+	key := ti.parent.Key()
+	ti.tkv.trace(traceOpIterKey, key, nil)
+	return key
+}
+
+// Implements Iterator.
+func (ti *traceIterator) Value() []byte {
+	value := ti.parent.Value()
+	ti.tkv.trace(traceOpIterValue, ti.parent.Key(), value)
+	return value
+}
+
+// Implements Iterator.
+func (ti *traceIterator) Close() {
+	ti.parent.Close()
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
+)
+
+// Decodes the traced operations.
+func readTraceOps(t *testing.T, buf *bytes.Buffer) []traceOperation {
+	ops := []traceOperation{}
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var op traceOperation
+		err := json.Unmarshal(line, &op)
+		assert.Nil(t, err)
+		ops = append(ops, op)
+	}
+	buf.Reset()
+	return ops
+}
+
+func TestTraceKVStore(t *testing.T) {
+	var buf bytes.Buffer
+	parent := dbStoreAdapter{dbm.NewMemDB()}
+	tc := TraceContext{"blockHeight": "1"}
+	store := NewTraceKVStore(parent, "store1", &buf, nil, tc)
+
+	store.Set(bz("key1"), bz("value1"))
+	assert.Equal(t, bz("value1"), store.Get(bz("key1")))
+	store.Delete(bz("key1"))
+	assert.Equal(t, []traceOperation{
+		{"store1", traceOpWrite, bz("key1"), bz("value1"), tc},
+		{"store1", traceOpRead, bz("key1"), bz("value1"), tc},
+		{"store1", traceOpDelete, bz("key1"), nil, tc},
+	}, readTraceOps(t, &buf))
+
+	// the context is read on each operation
+	tc["txIndex"] = "0"
+	store.Set(bz("key2"), bz("value2"))
+	iter := store.Iterator(nil, nil)
+	assert.Equal(t, bz("key2"), iter.Key())
+	assert.Equal(t, bz("value2"), iter.Value())
+	iter.Close()
+	assert.Equal(t, []traceOperation{
+		{"store1", traceOpWrite, bz("key2"), bz("value2"), tc},
+		{"store1", traceOpIterKey, bz("key2"), nil, tc},
+		{"store1", traceOpIterValue, bz("key2"), bz("value2"), tc},
+	}, readTraceOps(t, &buf))
+}
+
+// Fails every write after the first n.
+type failingWriter struct {
+	n      int
+	writes int
+}
+
+func (fw *failingWriter) Write(bz []byte) (int, error) {
+	fw.writes++
+	if fw.writes > fw.n {
+		return 0, errors.New("disk full")
+	}
+	return len(bz), nil
+}
+
+func TestTraceKVStoreWriteError(t *testing.T) {
+	fw := &failingWriter{n: 1}
+	var logs bytes.Buffer
+	logger := log.NewTMLogger(log.NewSyncWriter(&logs))
+	parent := dbStoreAdapter{dbm.NewMemDB()}
+	store := NewTraceKVStore(parent, "store1", fw, logger, TraceContext{})
+	store2 := NewTraceKVStore(parent, "store2", store.writer, nil, TraceContext{})
+
+	// the store still works, but stops tracing after the error,
+	// which is logged once
+	store.Set(bz("key1"), bz("value1"))
+	store.Set(bz("key2"), bz("value2"))
+	store.Set(bz("key3"), bz("value3"))
+	assert.Equal(t, bz("value3"), store2.Get(bz("key3")))
+	assert.Equal(t, 2, fw.writes)
+	assert.Equal(t, 1, strings.Count(logs.String(), "disk full"), logs.String())
+}
+
+func TestMultistoreTracing(t *testing.T) {
+	var buf bytes.Buffer
+	db := dbm.NewMemDB()
+	store := newMultiStoreWithMounts(db)
+	err := store.LoadLatestVersion()
+	assert.Nil(t, err)
+	key := store.keysByName["store1"]
+
+	// cache-wraps made before the tracer is set aren't traced
+	cms := store.CacheMultiStore()
+	store.SetTracer(&buf, nil)
+	assert.True(t, store.TracingEnabled())
+	cms.GetTracedKVStore(key, TraceContext{"blockHeight": "1"}).Set(bz("key"), bz("value"))
+	cms.Write()
+	assert.Empty(t, readTraceOps(t, &buf))
+
+	// the operations are traced with their context as they are made,
+	// and not again when the cache-wraps are written
+	cms = store.CacheMultiStore()
+	cache := cms.CacheMultiStore()
+	tc := TraceContext{"blockHeight": "2", "txIndex": "0"}
+	cache.GetTracedKVStore(key, tc).Set(bz("key"), bz("value2"))
+	cache.Write()
+	cms.Write()
+	assert.Equal(t, []traceOperation{
+		{"store1", traceOpWrite, bz("key"), bz("value2"), tc},
+	}, readTraceOps(t, &buf))
+
+	// GetKVStore isn't traced
+	store.CacheMultiStore().GetKVStore(key).Get(bz("key"))
+	store.GetKVStore(key).Get(bz("key"))
+	assert.Empty(t, readTraceOps(t, &buf))
+	store.GetTracedKVStore(key, TraceContext{}).Get(bz("missing"))
+	assert.Equal(t, []traceOperation{
+		{"store1", traceOpRead, bz("missing"), nil, TraceContext{}},
+	}, readTraceOps(t, &buf))
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	dbm "github.com/tendermint/tmlibs/db"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+var _ KVStore = (*transientStore)(nil)
+var _ CommitStore = (*transientStore)(nil)
+
+// transientStore is an in-memory KVStore that is wiped on every Commit.
+// It isn't part of the commit hash.
+// Implements KVStore and CommitStore.
+type transientStore struct {
+	dbStoreAdapter
+}
+
+func newTransientStore() *transientStore {
+	return &transientStore{dbStoreAdapter{dbm.NewMemDB()}}
+}
+
+// Implements Committer.
+// Wipes the store, and returns an empty CommitID.
+func (ts *transientStore) Commit() (id CommitID) {
+	ts.dbStoreAdapter = dbStoreAdapter{dbm.NewMemDB()}
+	return
+}
+
+// Implements Committer.
+func (ts *transientStore) LastCommitID() (id CommitID) {
+	return
+}
+
+// Implements Store.
+func (ts *transientStore) GetStoreType() StoreType {
+	return sdk.StoreTypeTransient
+}
+
+// Implements Store.
+func (ts *transientStore) CacheWrap() CacheWrap {
+	return NewCacheKVStore(ts)
+}
+
+// Implements KVStore.
+func (ts *transientStore) Gas(meter GasMeter, config GasConfig) KVStore {
+	return NewGasKVStore(meter, config, ts)
+}
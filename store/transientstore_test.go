@@ -0,0 +1,23 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransientStore(t *testing.T) {
+	tstore := newTransientStore()
+	k, v := []byte("hello"), []byte("world")
+
+	assert.Nil(t, tstore.Get(k))
+
+	tstore.Set(k, v)
+
+	assert.Equal(t, v, tstore.Get(k))
+
+	id := tstore.Commit()
+	assert.True(t, id.IsZero())
+
+	assert.Nil(t, tstore.Get(k))
+}
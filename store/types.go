@@ -20,3 +20,9 @@ type CommitID = types.CommitID
 type StoreKey = types.StoreKey
 type StoreType = types.StoreType
 type Queryable = types.Queryable
+type GasMeter = types.GasMeter
+type GasConfig = types.GasConfig
+type PruningOptions = types.PruningOptions
+type TraceContext = types.TraceContext
+type StoreUpgrades = types.StoreUpgrades
+type KVDiff = types.KVDiff
@@ -1,6 +1,10 @@
 package types
 
-import abci "github.com/tendermint/abci/types"
+import (
+	"encoding/json"
+
+	abci "github.com/tendermint/abci/types"
+)
 
 // initialize application state at genesis
 type InitChainer func(ctx Context, req abci.RequestInitChain) abci.ResponseInitChain
@@ -10,3 +14,6 @@ type BeginBlocker func(ctx Context, req abci.RequestBeginBlock) abci.ResponseBeg
 
 // run code after the transactions in a block and return updates to the validator set
 type EndBlocker func(ctx Context, req abci.RequestEndBlock) abci.ResponseEndBlock
+
+// export the application state at the context's height as the app state of a genesis file
+type AppStateExporter func(ctx Context) (json.RawMessage, error)
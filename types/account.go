@@ -4,12 +4,15 @@ import (
 	crypto "github.com/tendermint/go-crypto"
 )
 
-// Account is a standard account using a sequence number for replay protection
-// and a pubkey for authentication.
+// Account is a standard account using an account number and a sequence
+// number for replay protection and a pubkey for authentication.
 type Account interface {
 	GetAddress() crypto.Address
 	SetAddress(crypto.Address) error // errors if already set.
 
+	GetAccountNumber() int64
+	SetAccountNumber(int64) error
+
 	GetPubKey() crypto.PubKey // can return nil.
 	SetPubKey(crypto.PubKey) error
 
@@ -29,4 +32,7 @@ type AccountMapper interface {
 	NewAccountWithAddress(ctx Context, addr crypto.Address) Account
 	GetAccount(ctx Context, addr crypto.Address) Account
 	SetAccount(ctx Context, acc Account)
+	RemoveAccount(ctx Context, acc Account)
+	IterateAccounts(ctx Context, process func(Account) (stop bool))
+	GetNextAccountNumber(ctx Context) int64
 }
@@ -43,6 +43,7 @@ func NewContext(ms MultiStore, header abci.Header, isCheckTx bool, txBytes []byt
 	c = c.WithChainID(header.ChainID)
 	c = c.WithIsCheckTx(isCheckTx)
 	c = c.WithTxBytes(txBytes)
+	c = c.WithGasMeter(NewInfiniteGasMeter())
 	return c
 }
 
@@ -67,8 +68,10 @@ func (c Context) Value(key interface{}) interface{} {
 }
 
 // KVStore fetches a KVStore from the MultiStore.
+// Every access to the returned store consumes gas from the GasMeter,
+// and is traced along with the TraceContext if the MultiStore has a tracer.
 func (c Context) KVStore(key StoreKey) KVStore {
-	return c.multiStore().GetKVStore(key)
+	return c.multiStore().GetTracedKVStore(key, c.TraceContext()).Gas(c.GasMeter(), cachedKVGasConfig)
 }
 
 //----------------------------------------
@@ -126,6 +129,8 @@ const (
 	contextKeyChainID
 	contextKeyIsCheckTx
 	contextKeyTxBytes
+	contextKeyGasMeter
+	contextKeyTraceContext
 )
 
 // NOTE: Do not expose MultiStore.
@@ -151,6 +156,13 @@ func (c Context) IsCheckTx() bool {
 func (c Context) TxBytes() []byte {
 	return c.Value(contextKeyTxBytes).([]byte)
 }
+func (c Context) GasMeter() GasMeter {
+	return c.Value(contextKeyGasMeter).(GasMeter)
+}
+func (c Context) TraceContext() TraceContext {
+	tc, _ := c.Value(contextKeyTraceContext).(TraceContext)
+	return tc
+}
 func (c Context) WithMultiStore(ms MultiStore) Context {
 	return c.withValue(contextKeyMultiStore, ms)
 }
@@ -170,6 +182,27 @@ func (c Context) WithIsCheckTx(isCheckTx bool) Context {
 func (c Context) WithTxBytes(txBytes []byte) Context {
 	return c.withValue(contextKeyTxBytes, txBytes)
 }
+func (c Context) WithGasMeter(meter GasMeter) Context {
+	return c.withValue(contextKeyGasMeter, meter)
+}
+
+// WithTraceContext merges tc into a copy of the TraceContext, e.g. to
+// attribute the traced store operations to a tx.  A nil value removes
+// the key.
+func (c Context) WithTraceContext(tc TraceContext) Context {
+	merged := make(TraceContext)
+	for k, v := range c.TraceContext() {
+		merged[k] = v
+	}
+	for k, v := range tc {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return c.withValue(contextKeyTraceContext, merged)
+}
 
 //----------------------------------------
 // thePast
@@ -183,6 +216,9 @@ func (c Context) GetOp(ver int64) (Op, bool) {
 //----------------------------------------
 // Misc.
 
+// cachedKVGasConfig avoids rebuilding the default config on every access.
+var cachedKVGasConfig = KVGasConfig()
+
 type cloner interface {
 	Clone() interface{} // deep copy
 }
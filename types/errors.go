@@ -30,6 +30,8 @@ const (
 	CodeUnknownRequest      CodeType = 6
 	CodeUnrecognizedAddress CodeType = 7
 	CodeInvalidSequence     CodeType = 8
+	CodeOutOfGas            CodeType = 9
+	CodeInsufficientFee     CodeType = 10
 
 	CodeGenesisParse CodeType = 0xdead // TODO: remove ?
 )
@@ -55,6 +57,10 @@ func CodeToDefaultMsg(code CodeType) string {
 		return "Unrecognized address"
 	case CodeInvalidSequence:
 		return "Invalid sequence"
+	case CodeOutOfGas:
+		return "Out of gas"
+	case CodeInsufficientFee:
+		return "Insufficient fee"
 	default:
 		return fmt.Sprintf("Unknown code %d", code)
 	}
@@ -92,6 +98,12 @@ func ErrUnrecognizedAddress(addr crypto.Address) Error {
 func ErrInvalidSequence(msg string) Error {
 	return newError(CodeInvalidSequence, msg)
 }
+func ErrOutOfGas(msg string) Error {
+	return newError(CodeOutOfGas, msg)
+}
+func ErrInsufficientFee(msg string) Error {
+	return newError(CodeInsufficientFee, msg)
+}
 
 //----------------------------------------
 // Error & sdkError
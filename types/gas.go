@@ -0,0 +1,104 @@
+package types
+
+// Gas measures computation and storage access performed by a transaction.
+type Gas = int64
+
+// ErrorOutOfGas is the panic value used when a GasMeter exceeds its limit.
+// BaseApp.runTx recovers it and converts it into ErrOutOfGas.
+type ErrorOutOfGas struct {
+	Descriptor string
+}
+
+// GasMeter interface to track gas consumption
+type GasMeter interface {
+	GasConsumed() Gas
+	Limit() Gas
+	ConsumeGas(amount Gas, descriptor string)
+}
+
+type basicGasMeter struct {
+	limit    Gas
+	consumed Gas
+}
+
+// NewGasMeter returns a GasMeter which panics with ErrorOutOfGas once more
+// than limit gas has been consumed.
+func NewGasMeter(limit Gas) GasMeter {
+	return &basicGasMeter{
+		limit:    limit,
+		consumed: 0,
+	}
+}
+
+func (g *basicGasMeter) GasConsumed() Gas {
+	return g.consumed
+}
+
+func (g *basicGasMeter) Limit() Gas {
+	return g.limit
+}
+
+func (g *basicGasMeter) ConsumeGas(amount Gas, descriptor string) {
+	g.consumed += amount
+	if g.consumed > g.limit {
+		panic(ErrorOutOfGas{descriptor})
+	}
+}
+
+type infiniteGasMeter struct {
+	consumed Gas
+}
+
+// NewInfiniteGasMeter returns a GasMeter which counts gas but never runs out.
+// It is the default for contexts outside of transaction execution.
+func NewInfiniteGasMeter() GasMeter {
+	return &infiniteGasMeter{
+		consumed: 0,
+	}
+}
+
+func (g *infiniteGasMeter) GasConsumed() Gas {
+	return g.consumed
+}
+
+// Implements GasMeter. Zero means there is no limit.
+func (g *infiniteGasMeter) Limit() Gas {
+	return 0
+}
+
+func (g *infiniteGasMeter) ConsumeGas(amount Gas, descriptor string) {
+	g.consumed += amount
+}
+
+//----------------------------------------
+// GasConfig
+
+// GasConfig defines the gas cost of each KVStore operation.
+type GasConfig struct {
+	HasCost          Gas
+	ReadCostFlat     Gas
+	ReadCostPerByte  Gas
+	WriteCostFlat    Gas
+	WriteCostPerByte Gas
+	DeleteCost       Gas
+	KeyCostFlat      Gas
+	ValueCostFlat    Gas
+	ValueCostPerByte Gas
+	IterNextCostFlat Gas
+}
+
+// KVGasConfig returns the default gas costs for KVStore access.
+func KVGasConfig() GasConfig {
+	return GasConfig{
+		HasCost:          10,
+		ReadCostFlat:     10,
+		ReadCostPerByte:  1,
+		WriteCostFlat:    10,
+		WriteCostPerByte: 10,
+		DeleteCost:       10,
+		KeyCostFlat:      5,
+		ValueCostFlat:    10,
+		ValueCostPerByte: 1,
+		IterNextCostFlat: 30,
+	}
+}
@@ -1,7 +1,18 @@
 package types
 
+import (
+	abci "github.com/tendermint/abci/types"
+)
+
 // core function variable which application runs for transactions
 type Handler func(ctx Context, msg Msg) Result
 
 // If newCtx.IsZero(), ctx is used instead.
-type AnteHandler func(ctx Context, tx Tx) (newCtx Context, result Result, abort bool)
+// If simulate is true, the tx is only being simulated, e.g. to estimate
+// its gas, so its signatures should not be verified.
+type AnteHandler func(ctx Context, tx Tx, simulate bool) (newCtx Context, result Result, abort bool)
+
+// Querier answers the "/custom/<route>/<path...>" queries of a module.
+// path holds the elements after the route, and ctx is read-only:
+// anything written to it is discarded.
+type Querier func(ctx Context, path []string, req abci.RequestQuery) (res []byte, err Error)
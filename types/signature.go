@@ -6,5 +6,6 @@ import crypto "github.com/tendermint/go-crypto"
 type StdSignature struct {
 	crypto.PubKey // optional
 	crypto.Signature
-	Sequence int64
+	AccountNumber int64
+	Sequence      int64
 }
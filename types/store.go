@@ -2,9 +2,11 @@ package types
 
 import (
 	"fmt"
+	"io"
 
 	abci "github.com/tendermint/abci/types"
 	dbm "github.com/tendermint/tmlibs/db"
+	"github.com/tendermint/tmlibs/log"
 )
 
 // NOTE: These are implemented in cosmos-sdk/store.
@@ -48,8 +50,25 @@ type MultiStore interface { //nolint
 	// Convenience for fetching substores.
 	GetStore(StoreKey) Store
 	GetKVStore(StoreKey) KVStore
+
+	// Trace the operations on the KVStores returned by GetTracedKVStore,
+	// here and in the cache-wraps made from now on, as JSON lines written
+	// to w.  An error writing to w is logged to logger, and stops the
+	// tracing.  A nil w disables it.
+	SetTracer(w io.Writer, logger log.Logger)
+
+	// Returns true if a tracer is set.
+	TracingEnabled() bool
+
+	// GetKVStore, with the operations on it traced along with tc,
+	// e.g. the index of the current tx, if a tracer is set.
+	GetTracedKVStore(key StoreKey, tc TraceContext) KVStore
 }
 
+// TraceContext is the metadata written with each traced operation.
+// It is carried by the Context, see Context.WithTraceContext.
+type TraceContext map[string]interface{}
+
 // From MultiStore.CacheMultiStore()....
 type CacheMultiStore interface {
 	MultiStore
@@ -63,6 +82,9 @@ type CommitMultiStore interface {
 
 	// Mount a store of type using the given db.
 	// If db == nil, the new store will use the CommitMultiStore db.
+	// A StoreTypeMulti store needs its own db; it is a CommitMultiStore
+	// itself, whose substores are mounted via GetCommitStore(key).
+	// IAVL stores can share the CommitMultiStore db, but no other db.
 	MountStoreWithDB(key StoreKey, typ StoreType, db dbm.DB)
 
 	// Panics on a nil key.
@@ -75,8 +97,42 @@ type CommitMultiStore interface {
 	// Load a specific persisted version.  When you load an old
 	// version, or when the last commit attempt didn't complete,
 	// the next commit after loading must be idempotent (return the
-	// same commit id).  Otherwise the behavior is undefined.
+	// same commit id).  Otherwise the behavior is undefined, and
+	// RollbackToVersion should be used instead.
 	LoadVersion(ver int64) error
+
+	// Delete the persisted versions after ver, and load ver, so that
+	// the next commit is ver+1.  May be called instead of
+	// Load*Version().
+	RollbackToVersion(ver int64) error
+
+	// Set the pruning options of all stores, and of the commit records.
+	// Called before the first call to Load*Version().
+	SetPruning(PruningOptions)
+
+	// Set the upgrades of the stores at height, i.e. from the stores
+	// committed at height-1 to the mounted ones.  Called before the
+	// first call to Load*Version(), which then fails to load a version
+	// before height-1, as it was committed by a different layout.
+	SetStoreUpgrades(height int64, upgrades StoreUpgrades)
+
+	// Returns a cache-wrapped MultiStore of a persisted version,
+	// e.g. for queries of past state.  Writing the cache back is
+	// not supported, unless the version is the latest.
+	CacheMultiStoreWithVersion(ver int64) (CacheMultiStore, error)
+
+	// Export a snapshot of the latest version into dir, in chunks
+	// of about chunkSize bytes, e.g. to bootstrap another node.
+	ExportSnapshot(dir string, chunkSize int) (CommitID, error)
+
+	// Rebuild the stores from a snapshot in dir and load its version,
+	// instead of calling Load*Version().  Returns the verified CommitID.
+	ImportSnapshot(dir string) (CommitID, error)
+
+	// Calls fn with each key of the IAVL stores that differs between two
+	// persisted versions, e.g. to find where two nodes diverged, until fn
+	// returns true.
+	DiffVersions(from, to int64, fn func(storeName string, diff KVDiff) (stop bool)) error
 }
 
 //----------------------------------------
@@ -108,6 +164,10 @@ type KVStore interface {
 	// CONTRACT: No writes may happen within a domain while an iterator exists over it.
 	ReverseIterator(start, end []byte) Iterator
 
+	// Gas wraps the KVStore so that every access consumes gas from the
+	// GasMeter according to the GasConfig.
+	Gas(GasMeter, GasConfig) KVStore
+
 	// TODO Not yet implemented.
 	// CreateSubKVStore(key *storeKey) (KVStore, error)
 
@@ -170,6 +230,89 @@ func (cid CommitID) String() string {
 	return fmt.Sprintf("CommitID{%v:%X}", cid.Hash, cid.Version)
 }
 
+//----------------------------------------
+// Diffs
+
+// KVDiff is a key whose value differs between two versions.
+// Old is nil for an added key, and New for a deleted one.
+type KVDiff struct {
+	Key []byte
+	Old []byte
+	New []byte
+}
+
+//----------------------------------------
+// Pruning
+
+// PruningOptions defines which old versions of committed state are kept.
+// The KeepRecent latest versions are kept, and of the older versions,
+// those that are a multiple of KeepEvery. If KeepRecent is zero,
+// every version is kept, and if KeepEvery is zero, only the recent ones.
+type PruningOptions struct {
+	KeepRecent int64
+	KeepEvery  int64
+}
+
+// nolint - Common pruning options
+var (
+	// Keep every version, e.g. for archive nodes.
+	PruneNothing = PruningOptions{0, 0}
+	// Keep the 100 latest versions, and a snapshot every 10000 versions,
+	// e.g. for nodes that other nodes sync from.
+	PruneSyncable = PruningOptions{100, 10000}
+	// Keep only the latest version.
+	PruneEverything = PruningOptions{1, 0}
+)
+
+// Prunes returns whether version should be deleted once latest is committed.
+func (po PruningOptions) Prunes(version, latest int64) bool {
+	if po.KeepRecent <= 0 || version <= 0 {
+		return false
+	}
+	if latest-version < po.KeepRecent {
+		return false
+	}
+	if po.KeepEvery > 0 && version%po.KeepEvery == 0 {
+		return false
+	}
+	return true
+}
+
+//----------------------------------------
+// Store upgrades
+
+// StoreUpgrades describes how the mounted stores differ from the stores
+// committed before an upgrade, see CommitMultiStore.SetStoreUpgrades.
+type StoreUpgrades struct {
+	// Stores that are new, and start out empty.
+	Added []string
+	// Stores that are mounted under a new name, from old to new name.
+	Renamed map[string]string
+	// Stores that are no longer mounted, nor committed.  Their data
+	// is left in the db.
+	Deleted []string
+}
+
+// IsAdded returns whether the store name was added by the upgrades.
+func (su StoreUpgrades) IsAdded(name string) bool {
+	for _, added := range su.Added {
+		if added == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeleted returns whether the store name was deleted by the upgrades.
+func (su StoreUpgrades) IsDeleted(name string) bool {
+	for _, deleted := range su.Deleted {
+		if deleted == name {
+			return true
+		}
+	}
+	return false
+}
+
 //----------------------------------------
 // Store types
 
@@ -181,6 +324,7 @@ const (
 	StoreTypeMulti StoreType = iota
 	StoreTypeDB
 	StoreTypeIAVL
+	StoreTypeTransient
 )
 
 //----------------------------------------
@@ -213,3 +357,53 @@ func (key *KVStoreKey) Name() string {
 func (key *KVStoreKey) String() string {
 	return fmt.Sprintf("KVStoreKey{%p, %s}", key, key.name)
 }
+
+// TransientStoreKey is used for accessing transient substores,
+// which are wiped on every commit and are not part of the commit hash.
+// Only the pointer value should ever be used - it functions as a capabilities key.
+type TransientStoreKey struct {
+	name string
+}
+
+// NewTransientStoreKey returns a new pointer to a TransientStoreKey.
+// Use a pointer so keys don't collide.
+func NewTransientStoreKey(name string) *TransientStoreKey {
+	return &TransientStoreKey{
+		name: name,
+	}
+}
+
+func (key *TransientStoreKey) Name() string {
+	return key.name
+}
+
+func (key *TransientStoreKey) String() string {
+	return fmt.Sprintf("TransientStoreKey{%p, %s}", key, key.name)
+}
+
+// PrefixEndBytes returns the []byte that would end a
+// range query for all []byte with a certain prefix.
+// Deals with last byte of prefix being FF without overflowing.
+// Returns nil, meaning no end, if the prefix is all FF.
+func PrefixEndBytes(prefix []byte) []byte {
+	if len(prefix) == 0 {
+		return nil
+	}
+
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+
+	for {
+		if end[len(end)-1] != byte(255) {
+			end[len(end)-1]++
+			break
+		} else {
+			end = end[:len(end)-1]
+			if len(end) == 0 {
+				end = nil
+				break
+			}
+		}
+	}
+	return end
+}
@@ -0,0 +1,48 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixEndBytes(t *testing.T) {
+	var testCases = []struct {
+		prefix   []byte
+		expected []byte
+	}{
+		{[]byte{byte(55), byte(255), byte(255), byte(0)}, []byte{byte(55), byte(255), byte(255), byte(1)}},
+		{[]byte{byte(55), byte(255), byte(255), byte(15)}, []byte{byte(55), byte(255), byte(255), byte(16)}},
+		{[]byte{byte(55), byte(200), byte(255)}, []byte{byte(55), byte(201)}},
+		{[]byte{byte(55), byte(255), byte(255)}, []byte{byte(56)}},
+		{[]byte{byte(255), byte(255), byte(255)}, nil},
+		{nil, nil},
+	}
+
+	for _, test := range testCases {
+		end := PrefixEndBytes(test.prefix)
+		assert.Equal(t, test.expected, end)
+	}
+}
+
+func TestPruningOptions(t *testing.T) {
+	var testCases = []struct {
+		pruning         PruningOptions
+		version, latest int64
+		expected        bool
+	}{
+		{PruneNothing, 1, 1000, false},
+		{PruneEverything, 1, 1, false},
+		{PruneEverything, 1, 2, true},
+		{PruningOptions{3, 0}, 7, 9, false},
+		{PruningOptions{3, 0}, 7, 10, true},
+		{PruningOptions{3, 5}, 10, 20, false},
+		{PruningOptions{3, 5}, 11, 20, true},
+		{PruningOptions{3, 5}, 0, 20, false},
+	}
+
+	for _, test := range testCases {
+		prunes := test.pruning.Prunes(test.version, test.latest)
+		assert.Equal(t, test.expected, prunes, "%v %d %d", test.pruning, test.version, test.latest)
+	}
+}
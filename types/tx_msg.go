@@ -1,6 +1,8 @@
 package types
 
 import (
+	"encoding/json"
+
 	crypto "github.com/tendermint/go-crypto"
 )
 
@@ -30,14 +32,15 @@ type Msg interface {
 // Transactions objects must fulfill the Tx
 type Tx interface {
 
-	// Gets the Msg.
-	GetMsg() Msg
+	// Gets the Msgs, in the order they must be run.
+	// They are run atomically: either all of them succeed or none do.
+	GetMsgs() []Msg
 
-	// The address that pays the base fee for this message.  The fee is
-	// deducted before the Msg is processed.
+	// The address that pays the base fee for this tx.  The fee is
+	// deducted before the Msgs are processed.
 	GetFeePayer() crypto.Address
 
-	// Signatures returns the signature of signers who signed the Msg.
+	// Signatures returns the signature of signers who signed the Msgs.
 	// CONTRACT: Length returned is same as length of
 	// pubkeys returned from MsgKeySigners, and the order
 	// matches.
@@ -49,25 +52,110 @@ type Tx interface {
 
 var _ Tx = (*StdTx)(nil)
 
-// StdTx is a standard way to wrap a Msg with Signatures.
+// StdTx is a standard way to wrap Msgs with Fee and Signatures.
 // NOTE: the first signature is the FeePayer (Signatures must not be nil).
 type StdTx struct {
-	Msg
+	Msgs       []Msg
+	Fee        StdFee
 	Signatures []StdSignature
 }
 
-func NewStdTx(msg Msg, sigs []StdSignature) StdTx {
+func NewStdTx(msgs []Msg, fee StdFee, sigs []StdSignature) StdTx {
 	return StdTx{
-		Msg:        msg,
+		Msgs:       msgs,
+		Fee:        fee,
 		Signatures: sigs,
 	}
 }
 
 //nolint
-func (tx StdTx) GetMsg() Msg                   { return tx.Msg }
-func (tx StdTx) GetFeePayer() crypto.Address   { return tx.Signatures[0].PubKey.Address() } // XXX but PubKey is optional!
+func (tx StdTx) GetMsgs() []Msg                { return tx.Msgs }
+func (tx StdTx) GetFeePayer() crypto.Address   { return tx.GetSigners()[0] }
 func (tx StdTx) GetSignatures() []StdSignature { return tx.Signatures }
 
+// GetSigners returns the signers of all the Msgs, in the order
+// they first appear, without duplicates.
+// CONTRACT: Signatures must be in the same order.
+func (tx StdTx) GetSigners() []crypto.Address {
+	seen := map[string]bool{}
+	var signers []crypto.Address
+	for _, msg := range tx.Msgs {
+		for _, addr := range msg.GetSigners() {
+			if !seen[string(addr)] {
+				signers = append(signers, addr)
+				seen[string(addr)] = true
+			}
+		}
+	}
+	return signers
+}
+
+//__________________________________________________________
+
+// StdFee includes the amount of coins paid in fees and the maximum
+// gas to be used by the transaction.
+type StdFee struct {
+	Amount Coins `json:"amount"`
+	Gas    Gas   `json:"gas"`
+}
+
+func NewStdFee(gas Gas, amount ...Coin) StdFee {
+	return StdFee{
+		Amount: amount,
+		Gas:    gas,
+	}
+}
+
+// Bytes returns the canonical byte representation of the fee.
+func (fee StdFee) Bytes() []byte {
+	// Normalize nil to empty, so both encode as [].
+	if len(fee.Amount) == 0 {
+		fee.Amount = Coins{}
+	}
+	bz, err := json.Marshal(fee) // XXX: ensure some canonical form
+	if err != nil {
+		panic(err)
+	}
+	return bz
+}
+
+//__________________________________________________________
+
+// StdSignDoc is replay-prevention structure.
+// It includes the result of msg.GetSignBytes() for each Msg,
+// as well as the Fee, so that the fee payer
+// signs off on the fee as well as the msgs.
+// The chain ID, and the account number and sequence of each signer,
+// keep the signatures from being replayed on another chain, or
+// against a removed and then recreated account.
+type StdSignDoc struct {
+	ChainID        string   `json:"chain_id"`
+	AccountNumbers []int64  `json:"account_numbers"`
+	Sequences      []int64  `json:"sequences"`
+	FeeBytes       []byte   `json:"fee_bytes"`
+	MsgsBytes      [][]byte `json:"msgs_bytes"`
+}
+
+// StdSignBytes returns the bytes to sign for a transaction.
+// The account numbers and sequences are those of the signers, in order.
+func StdSignBytes(chainID string, accnums []int64, sequences []int64, fee StdFee, msgs []Msg) []byte {
+	msgsBytes := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		msgsBytes[i] = msg.GetSignBytes()
+	}
+	bz, err := json.Marshal(StdSignDoc{
+		ChainID:        chainID,
+		AccountNumbers: accnums,
+		Sequences:      sequences,
+		FeeBytes:       fee.Bytes(),
+		MsgsBytes:      msgsBytes,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return bz
+}
+
 //-------------------------------------
 
 // Application function variable used to unmarshal transaction bytes
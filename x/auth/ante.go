@@ -1,29 +1,40 @@
 package auth
 
 import (
+	"bytes"
+	"fmt"
+
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
+// NewAnteHandler returns an AnteHandler that checks account numbers,
+// checks and increments sequence numbers, checks signatures,
+// and deducts fees from the first signer.
+// When simulating, signatures are not verified and gas is not limited.
 func NewAnteHandler(accountMapper sdk.AccountMapper) sdk.AnteHandler {
 	return func(
-		ctx sdk.Context, tx sdk.Tx,
+		ctx sdk.Context, tx sdk.Tx, simulate bool,
 	) (_ sdk.Context, _ sdk.Result, abort bool) {
 
-		// Deduct the fee from the fee payer.
-		// This is done first because it only
-		// requires fetching 1 account.
-		payerAddr := tx.GetFeePayer()
-		if payerAddr != nil {
-			payerAcc := accountMapper.GetAccount(ctx, payerAddr)
-			if payerAcc == nil {
-				return ctx,
-					sdk.ErrUnrecognizedAddress(payerAddr).Result(),
-					true
-			}
-			// TODO: Charge fee from payerAcc.
-			// TODO: accountMapper.SetAccount(ctx, payerAddr)
+		// This AnteHandler requires Txs to be StdTxs
+		stdTx, ok := tx.(sdk.StdTx)
+		if !ok {
+			return ctx,
+				sdk.ErrInternal("tx must be sdk.StdTx").Result(),
+				true
+		}
+
+		// The fee sets the gas limit for the rest of the tx.
+		fee := stdTx.Fee
+		if fee.Gas < 0 || !fee.Amount.IsValid() || !fee.Amount.IsNotNegative() {
+			return ctx,
+				sdk.ErrTxParse(fmt.Sprintf("invalid fee %s", fee.Bytes())).Result(),
+				true
+		}
+		if simulate {
+			ctx = ctx.WithGasMeter(sdk.NewInfiniteGasMeter())
 		} else {
-			// TODO: Ensure that some other spam prevention is used.
+			ctx = ctx.WithGasMeter(sdk.NewGasMeter(fee.Gas))
 		}
 
 		var sigs = tx.GetSignatures()
@@ -36,8 +47,7 @@ func NewAnteHandler(accountMapper sdk.AccountMapper) sdk.AnteHandler {
 		}
 
 		// Ensure that sigs are correct.
-		var msg = tx.GetMsg()
-		var signerAddrs = msg.GetSigners()
+		var signerAddrs = stdTx.GetSigners()
 		var signerAccs = make([]sdk.Account, len(signerAddrs))
 
 		// Assert that number of signatures is correct.
@@ -47,16 +57,47 @@ func NewAnteHandler(accountMapper sdk.AccountMapper) sdk.AnteHandler {
 				true
 		}
 
-		// Check each nonce and sig.
+		// Signers of all the msgs sign the same bytes, which include the
+		// chain ID and the account number and sequence of every signer.
+		// Each sig's account number and sequence is checked below.
+		var accNums = make([]int64, len(sigs))
+		var seqs = make([]int64, len(sigs))
+		for i, sig := range sigs {
+			accNums[i] = sig.AccountNumber
+			seqs[i] = sig.Sequence
+		}
+		var signBytes = sdk.StdSignBytes(ctx.ChainID(), accNums, seqs, fee, stdTx.GetMsgs())
+
+		// Check each account number, nonce and sig.
 		// TODO Refactor out.
 		for i, sig := range sigs {
 
 			var signerAcc = accountMapper.GetAccount(ctx, signerAddrs[i])
+			if signerAcc == nil {
+				return ctx,
+					sdk.ErrUnrecognizedAddress(signerAddrs[i]).Result(),
+					true
+			}
 			signerAccs[i] = signerAcc
 
-			// If no pubkey, set pubkey.
-			if signerAcc.GetPubKey() == nil {
-				err := signerAcc.SetPubKey(sig.PubKey)
+			// Check the account number, which changes if the account
+			// was removed and then recreated.
+			if signerAcc.GetAccountNumber() != sig.AccountNumber {
+				return ctx,
+					sdk.ErrUnauthorized("wrong account number").Result(),
+					true
+			}
+
+			// If no pubkey, set pubkey, which must be the signer's.
+			pubKey := signerAcc.GetPubKey()
+			if pubKey == nil {
+				pubKey = sig.PubKey
+				if pubKey == nil || !bytes.Equal(pubKey.Address(), signerAddrs[i]) {
+					return ctx,
+						sdk.ErrUnauthorized("PubKey doesn't match the signer address").Result(),
+						true
+				}
+				err := signerAcc.SetPubKey(pubKey)
 				if err != nil {
 					return ctx,
 						sdk.ErrInternal("setting PubKey on signer").Result(),
@@ -73,8 +114,8 @@ func NewAnteHandler(accountMapper sdk.AccountMapper) sdk.AnteHandler {
 			}
 			signerAcc.SetSequence(seq + 1)
 
-			// Check sig.
-			if !sig.PubKey.VerifyBytes(msg.GetSignBytes(), sig.Signature) {
+			// Check sig, unless simulating.
+			if !simulate && !pubKey.VerifyBytes(signBytes, sig.Signature) {
 				return ctx,
 					sdk.ErrUnauthorized("").Result(),
 					true
@@ -84,7 +125,31 @@ func NewAnteHandler(accountMapper sdk.AccountMapper) sdk.AnteHandler {
 			accountMapper.SetAccount(ctx, signerAcc)
 		}
 
+		// Deduct the fee from the fee payer, the first signer.
+		// This is done last, so that only a verified signer pays.
+		payerAcc := signerAccs[0]
+		if !fee.Amount.IsZero() {
+			coins := payerAcc.GetCoins()
+			newCoins := coins.Minus(fee.Amount)
+			if !newCoins.IsNotNegative() {
+				errMsg := fmt.Sprintf("%s < %s", coins, fee.Amount)
+				return ctx,
+					sdk.ErrInsufficientFunds(errMsg).Result(),
+					true
+			}
+			payerAcc.SetCoins(newCoins)
+			accountMapper.SetAccount(ctx, payerAcc)
+		}
+
 		ctx = WithSigners(ctx, signerAccs)
-		return ctx, sdk.Result{}, false // continue...
+
+		// Report the fee, e.g. for the mempool.
+		// NOTE: Tendermint only takes a single denomination.
+		res := sdk.Result{}
+		if len(fee.Amount) > 0 {
+			res.FeeAmount = fee.Amount[0].Amount
+			res.FeeDenom = fee.Amount[0].Denom
+		}
+		return ctx, res, false // continue...
 	}
 }
@@ -7,9 +7,17 @@ import (
 	crypto "github.com/tendermint/go-crypto"
 	wire "github.com/tendermint/go-wire"
 
+	"github.com/cosmos/cosmos-sdk/store"
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
+// The accounts are stored by address under this prefix, so that
+// they can share a store with other data.
+var accountKeyPrefix = []byte("account:")
+
+// The next account number is stored under this key.
+var globalAccountNumberKey = []byte("globalAccountNumber")
+
 // Implements sdk.AccountMapper.
 // This AccountMapper encodes/decodes accounts using the
 // go-wire (binary) encoding/decoding library.
@@ -66,15 +74,18 @@ func (am accountMapper) Seal() sealedAccountMapper {
 }
 
 // Implements sdk.AccountMapper.
+// Each new account takes the next global account number, even if an
+// account with the same address was removed before.
 func (am accountMapper) NewAccountWithAddress(ctx sdk.Context, addr crypto.Address) sdk.Account {
 	acc := am.clonePrototype()
 	acc.SetAddress(addr)
+	acc.SetAccountNumber(am.GetNextAccountNumber(ctx))
 	return acc
 }
 
 // Implements sdk.AccountMapper.
 func (am accountMapper) GetAccount(ctx sdk.Context, addr crypto.Address) sdk.Account {
-	store := ctx.KVStore(am.key)
+	store := am.accountStore(ctx)
 	bz := store.Get(addr)
 	if bz == nil {
 		return nil
@@ -86,11 +97,54 @@ func (am accountMapper) GetAccount(ctx sdk.Context, addr crypto.Address) sdk.Acc
 // Implements sdk.AccountMapper.
 func (am accountMapper) SetAccount(ctx sdk.Context, acc sdk.Account) {
 	addr := acc.GetAddress()
-	store := ctx.KVStore(am.key)
+	store := am.accountStore(ctx)
 	bz := am.encodeAccount(acc)
 	store.Set(addr, bz)
 }
 
+// Implements sdk.AccountMapper.
+// A recreated account gets a new account number, so the signatures
+// for the removed one can't be replayed, see NewAccountWithAddress.
+func (am accountMapper) RemoveAccount(ctx sdk.Context, acc sdk.Account) {
+	addr := acc.GetAddress()
+	am.accountStore(ctx).Delete(addr)
+}
+
+// Implements sdk.AccountMapper.
+// Iterates over the accounts in order of addresses, until process
+// returns true.
+func (am accountMapper) IterateAccounts(ctx sdk.Context, process func(sdk.Account) (stop bool)) {
+	store := am.accountStore(ctx)
+	iter := store.Iterator(nil, nil)
+	defer iter.Close()
+	for ; iter.Valid(); iter.Next() {
+		acc := am.decodeAccount(iter.Value())
+		if process(acc) {
+			return
+		}
+	}
+}
+
+// Implements sdk.AccountMapper.
+// Returns the next global account number, and increments it.
+func (am accountMapper) GetNextAccountNumber(ctx sdk.Context) int64 {
+	store := ctx.KVStore(am.key)
+	var accNumber int64
+	bz := store.Get(globalAccountNumberKey)
+	if bz != nil {
+		err := am.cdc.UnmarshalBinary(bz, &accNumber)
+		if err != nil {
+			panic(err)
+		}
+	}
+	bz, err := am.cdc.MarshalBinary(accNumber + 1)
+	if err != nil {
+		panic(err)
+	}
+	store.Set(globalAccountNumberKey, bz)
+	return accNumber
+}
+
 //----------------------------------------
 // sealedAccountMapper
 
@@ -107,6 +161,11 @@ func (sam sealedAccountMapper) WireCodec() *wire.Codec {
 //----------------------------------------
 // misc.
 
+// Returns the accounts of the store, keyed by address.
+func (am accountMapper) accountStore(ctx sdk.Context) sdk.KVStore {
+	return store.NewPrefixStore(ctx.KVStore(am.key), accountKeyPrefix)
+}
+
 func (am accountMapper) clonePrototypePtr() interface{} {
 	protoRt := reflect.TypeOf(am.proto)
 	if protoRt.Kind() == reflect.Ptr {
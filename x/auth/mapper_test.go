@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	abci "github.com/tendermint/abci/types"
+	crypto "github.com/tendermint/go-crypto"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+func setupMultiStore() (sdk.MultiStore, *sdk.KVStoreKey) {
+	db := dbm.NewMemDB()
+	capKey := sdk.NewKVStoreKey("capkey")
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(capKey, sdk.StoreTypeIAVL, db)
+	ms.LoadLatestVersion()
+	return ms, capKey
+}
+
+func TestAccountMapperIterate(t *testing.T) {
+	ms, capKey := setupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil)
+	mapper := NewAccountMapper(capKey, &BaseAccount{})
+	RegisterWireBaseAccount(mapper.WireCodec())
+
+	// unrelated keys in the same store are skipped
+	ctx.KVStore(capKey).Set([]byte("a"), []byte("not an account"))
+	ctx.KVStore(capKey).Set([]byte("z"), []byte("not an account"))
+
+	addrs := []crypto.Address{
+		crypto.Address([]byte("addr1")),
+		crypto.Address([]byte("addr2")),
+		crypto.Address([]byte("addr3")),
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		acc := mapper.NewAccountWithAddress(ctx, addrs[i])
+		mapper.SetAccount(ctx, acc)
+	}
+	assert.Equal(t, addrs[1], mapper.GetAccount(ctx, addrs[1]).GetAddress())
+	assert.Nil(t, mapper.GetAccount(ctx, crypto.Address([]byte("a"))))
+
+	// in order of addresses
+	iterated := []crypto.Address{}
+	mapper.IterateAccounts(ctx, func(acc sdk.Account) bool {
+		iterated = append(iterated, acc.GetAddress())
+		return false
+	})
+	assert.Equal(t, addrs, iterated)
+
+	// until stopped
+	iterated = []crypto.Address{}
+	mapper.IterateAccounts(ctx, func(acc sdk.Account) bool {
+		iterated = append(iterated, acc.GetAddress())
+		return len(iterated) == 2
+	})
+	require.Equal(t, 2, len(iterated))
+	assert.Equal(t, addrs[:2], iterated)
+}
+
+func TestAccountMapperRemove(t *testing.T) {
+	ms, capKey := setupMultiStore()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil)
+	mapper := NewAccountMapper(capKey, &BaseAccount{})
+	RegisterWireBaseAccount(mapper.WireCodec())
+
+	addr1, addr2 := crypto.Address([]byte("addr1")), crypto.Address([]byte("addr2"))
+	acc1 := mapper.NewAccountWithAddress(ctx, addr1)
+	acc1.SetSequence(7)
+	mapper.SetAccount(ctx, acc1)
+	acc2 := mapper.NewAccountWithAddress(ctx, addr2)
+	mapper.SetAccount(ctx, acc2)
+	assert.Equal(t, int64(0), acc1.GetAccountNumber())
+	assert.Equal(t, int64(1), acc2.GetAccountNumber())
+
+	mapper.RemoveAccount(ctx, acc1)
+	mapper.RemoveAccount(ctx, acc2)
+	assert.Nil(t, mapper.GetAccount(ctx, addr1))
+	assert.Nil(t, mapper.GetAccount(ctx, addr2))
+	mapper.IterateAccounts(ctx, func(acc sdk.Account) bool {
+		t.Errorf("unexpected account %v", acc)
+		return false
+	})
+
+	// a recreated account starts over with a new account number
+	acc1 = mapper.NewAccountWithAddress(ctx, addr1)
+	assert.Equal(t, int64(2), acc1.GetAccountNumber())
+	assert.Equal(t, int64(0), acc1.GetSequence())
+
+	// numbers can be taken without creating an account, e.g. at genesis
+	assert.Equal(t, int64(3), mapper.GetNextAccountNumber(ctx))
+	acc2 = mapper.NewAccountWithAddress(ctx, addr2)
+	assert.Equal(t, int64(4), acc2.GetAccountNumber())
+}
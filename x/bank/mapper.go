@@ -8,16 +8,45 @@ import (
 	sdk "github.com/cosmos/cosmos-sdk/types"
 )
 
+// AccountPruning decides which accounts the CoinKeeper removes once
+// their coins are all subtracted, so that dust accounts don't live forever.
+type AccountPruning int
+
+const (
+	// Keep every account.
+	PruneNoAccounts AccountPruning = iota
+	// Remove the accounts that never signed a tx.
+	PruneUnusedAccounts
+	// Remove every account, as a recreated account gets a new account number.
+	PruneEmptyAccounts
+)
+
 // CoinKeeper manages transfers between accounts
 type CoinKeeper struct {
-	am sdk.AccountMapper
+	am      sdk.AccountMapper
+	pruning AccountPruning
 }
 
-// NewCoinKeeper returns a new CoinKeeper
+// NewCoinKeeper returns a new CoinKeeper, which keeps every account
 func NewCoinKeeper(am sdk.AccountMapper) CoinKeeper {
 	return CoinKeeper{am: am}
 }
 
+// NewCoinKeeperWithPruning returns a new CoinKeeper, which removes the
+// empty accounts according to pruning
+func NewCoinKeeperWithPruning(am sdk.AccountMapper, pruning AccountPruning) CoinKeeper {
+	return CoinKeeper{am: am, pruning: pruning}
+}
+
+// GetCoins returns the coins at the addr.
+func (ck CoinKeeper) GetCoins(ctx sdk.Context, addr crypto.Address) sdk.Coins {
+	acc := ck.am.GetAccount(ctx, addr)
+	if acc == nil {
+		return sdk.Coins{}
+	}
+	return acc.GetCoins()
+}
+
 // SubtractCoins subtracts amt from the coins at the addr.
 func (ck CoinKeeper) SubtractCoins(ctx sdk.Context, addr crypto.Address, amt sdk.Coins) (sdk.Coins, sdk.Error) {
 	acc := ck.am.GetAccount(ctx, addr)
@@ -32,10 +61,29 @@ func (ck CoinKeeper) SubtractCoins(ctx sdk.Context, addr crypto.Address, amt sdk
 	}
 
 	acc.SetCoins(newCoins)
-	ck.am.SetAccount(ctx, acc)
+	if ck.prunes(acc) {
+		ck.am.RemoveAccount(ctx, acc)
+	} else {
+		ck.am.SetAccount(ctx, acc)
+	}
 	return newCoins, nil
 }
 
+// Returns whether the account is removed, once its coins are subtracted.
+func (ck CoinKeeper) prunes(acc sdk.Account) bool {
+	if !acc.GetCoins().IsZero() {
+		return false
+	}
+	switch ck.pruning {
+	case PruneUnusedAccounts:
+		return acc.GetSequence() == 0
+	case PruneEmptyAccounts:
+		return true
+	default:
+		return false
+	}
+}
+
 // AddCoins adds amt to the coins at the addr.
 func (ck CoinKeeper) AddCoins(ctx sdk.Context, addr crypto.Address, amt sdk.Coins) (sdk.Coins, sdk.Error) {
 	acc := ck.am.GetAccount(ctx, addr)
@@ -0,0 +1,68 @@
+package bank
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	abci "github.com/tendermint/abci/types"
+	crypto "github.com/tendermint/go-crypto"
+	dbm "github.com/tendermint/tmlibs/db"
+
+	"github.com/cosmos/cosmos-sdk/store"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/x/auth"
+)
+
+func setupContext() (sdk.Context, sdk.AccountMapper) {
+	db := dbm.NewMemDB()
+	capKey := sdk.NewKVStoreKey("capkey")
+	ms := store.NewCommitMultiStore(db)
+	ms.MountStoreWithDB(capKey, sdk.StoreTypeIAVL, db)
+	ms.LoadLatestVersion()
+	ctx := sdk.NewContext(ms, abci.Header{}, false, nil)
+	return ctx, auth.NewAccountMapperSealed(capKey, &auth.BaseAccount{})
+}
+
+func TestCoinKeeperPruning(t *testing.T) {
+	coins := sdk.Coins{{"atom", 10}}
+	addrUnused, addrUsed := crypto.Address([]byte("unused")), crypto.Address([]byte("used"))
+
+	cases := []struct {
+		pruning     AccountPruning
+		keepsUnused bool
+		keepsUsed   bool
+	}{
+		{PruneNoAccounts, true, true},
+		{PruneUnusedAccounts, false, true},
+		{PruneEmptyAccounts, false, false},
+	}
+	for _, tc := range cases {
+		ctx, am := setupContext()
+		ck := NewCoinKeeperWithPruning(am, tc.pruning)
+		for _, addr := range []crypto.Address{addrUnused, addrUsed} {
+			_, err := ck.AddCoins(ctx, addr, coins)
+			assert.Nil(t, err)
+		}
+		acc := am.GetAccount(ctx, addrUsed)
+		acc.SetSequence(3)
+		am.SetAccount(ctx, acc)
+
+		// only empty accounts are pruned
+		_, err := ck.SubtractCoins(ctx, addrUsed, sdk.Coins{{"atom", 1}})
+		assert.Nil(t, err)
+		assert.NotNil(t, am.GetAccount(ctx, addrUsed))
+
+		for _, addr := range []crypto.Address{addrUnused, addrUsed} {
+			_, err := ck.SubtractCoins(ctx, addr, ck.GetCoins(ctx, addr))
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, tc.keepsUnused, am.GetAccount(ctx, addrUnused) != nil, "%v", tc.pruning)
+		assert.Equal(t, tc.keepsUsed, am.GetAccount(ctx, addrUsed) != nil, "%v", tc.pruning)
+
+		// a pruned account is recreated with a new account number
+		_, err = ck.AddCoins(ctx, addrUsed, coins)
+		assert.Nil(t, err)
+		recreated := am.GetAccount(ctx, addrUsed)
+		assert.Equal(t, tc.keepsUsed, recreated.GetAccountNumber() == acc.GetAccountNumber(), "%v", tc.pruning)
+	}
+}
@@ -0,0 +1,37 @@
+package bank
+
+import (
+	"encoding/json"
+
+	abci "github.com/tendermint/abci/types"
+	crypto "github.com/tendermint/go-crypto"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+// Answer all "/custom/bank/..." queries.
+func NewQuerier(ck CoinKeeper) sdk.Querier {
+	return func(ctx sdk.Context, path []string, req abci.RequestQuery) ([]byte, sdk.Error) {
+		if len(path) == 0 {
+			return nil, sdk.ErrUnknownRequest("Missing bank query path")
+		}
+		switch path[0] {
+		case "balance":
+			return queryBalance(ctx, ck, req)
+		default:
+			errMsg := "Unrecognized bank query path: " + path[0]
+			return nil, sdk.ErrUnknownRequest(errMsg)
+		}
+	}
+}
+
+// Query "/custom/bank/balance".
+// Data holds the address, the JSON encoded coins are returned.
+func queryBalance(ctx sdk.Context, ck CoinKeeper, req abci.RequestQuery) ([]byte, sdk.Error) {
+	coins := ck.GetCoins(ctx, crypto.Address(req.Data))
+	bz, err := json.Marshal(coins)
+	if err != nil {
+		return nil, sdk.ErrInternal(err.Error())
+	}
+	return bz, nil
+}